@@ -0,0 +1,946 @@
+// Package asm assembles Octo source into ROMs for CHIP-8, SCHIP and
+// XO-CHIP. It is a single pass over the tokens: forward references to
+// labels are patched once the whole program has been read.
+package asm
+
+import (
+  "fmt"
+  "math"
+  "strconv"
+  "strings"
+
+  "cryp-8/symbols"
+)
+
+const (
+  start      = 0x200
+  memorySize = 0x10000
+  // maxExpansions stops a macro that expands itself from running forever.
+  maxExpansions = 100000
+)
+
+// Error is an assembly error at a place in the source.
+type Error struct {
+  File string
+  Line int
+  Col  int
+  Msg  string
+}
+
+func (e *Error) Error() string {
+  return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// Program is an assembled ROM, ready for cpu.LoadRom, and the symbol map
+// relating it back to the source.
+type Program struct {
+  ROM     []byte
+  Symbols *symbols.Map
+}
+
+type macro struct {
+  args []string
+  body []token
+}
+
+// fixup patches a reference to a label that wasn't defined yet.
+type fixup struct {
+  at    token
+  patch func(v int) error
+}
+
+// block is an open if ... begin, else or loop. addr is the jump to patch
+// for if and else, and the top of the loop for loop; exits are the jumps
+// out of a loop made by while.
+type block struct {
+  kind  string
+  at    token
+  addr  int
+  exits []int
+}
+
+type assembler struct {
+  toks []token
+  // last is the most recently read token, for errors at the end of the
+  // source.
+  last token
+  rom  []byte
+  here int
+  // end is one past the highest address written.
+  end        int
+  labels     map[string]int
+  consts     map[string]float64
+  aliases    map[string]uint8
+  macros     map[string]*macro
+  fixups     []fixup
+  blocks     []block
+  lines      []symbols.Line
+  stmt       token
+  recorded   bool
+  expansions int
+}
+
+// Assemble assembles src, naming file in errors and the symbol map. Errors
+// are of type *Error.
+//
+// As in Octo, the program starts at 0x200 and is entered at the label
+// main: if main doesn't land on 0x200, the source is assembled again with
+// a jump to main put there first. A program without main is an error.
+func Assemble(file string, src []byte) (*Program, error) {
+  a, err := pass(file, src, false)
+  if err != nil {
+    return nil, err
+  }
+  if addr, ok := a.labels["main"]; !ok {
+    return nil, a.errorf(token{file: file, line: 1, col: 1}, "program has no main label")
+  } else if addr != start {
+    if a, err = pass(file, src, true); err != nil {
+      return nil, err
+    }
+  }
+  m := &symbols.Map{Lines: a.lines, Labels: map[string]uint16{}}
+  for name, addr := range a.labels {
+    m.Labels[name] = uint16(addr)
+  }
+  return &Program{ROM: a.rom[start:a.end], Symbols: m}, nil
+}
+
+// pass makes one pass over the source, first emitting a jump to main
+// if jumpMain is set.
+func pass(file string, src []byte, jumpMain bool) (*assembler, error) {
+  a := &assembler{
+    toks:    tokenize(file, string(src)),
+    last:    token{file: file, line: 1, col: 1},
+    rom:     make([]byte, memorySize),
+    here:    start,
+    end:     start,
+    labels:  map[string]int{},
+    consts:  map[string]float64{},
+    aliases: map[string]uint8{},
+    macros:  map[string]*macro{},
+  }
+  if jumpMain {
+    // the jump has no source line of its own
+    a.recorded = true
+    if err := a.jumpTo(0x1000, token{"main", file, 1, 1}); err != nil {
+      return nil, err
+    }
+  }
+  for len(a.toks) > 0 {
+    if err := a.statement(); err != nil {
+      return nil, err
+    }
+  }
+  if len(a.blocks) > 0 {
+    b := a.blocks[len(a.blocks) - 1]
+    return nil, a.errorf(b.at, "%s is never closed", b.kind)
+  }
+  for _, f := range a.fixups {
+    v, ok := a.labels[f.at.text]
+    if !ok {
+      return nil, a.errorf(f.at, "undefined name %q", f.at.text)
+    }
+    if err := f.patch(v); err != nil {
+      return nil, err
+    }
+  }
+  return a, nil
+}
+
+func (a *assembler) errorf(t token, format string, args ...any) error {
+  return &Error{t.file, t.line, t.col, fmt.Sprintf(format, args...)}
+}
+
+func (a *assembler) next() (token, error) {
+  if len(a.toks) == 0 {
+    return a.last, a.errorf(a.last, "unexpected end of source after %q", a.last.text)
+  }
+  a.last = a.toks[0]
+  a.toks = a.toks[1:]
+  return a.last, nil
+}
+
+func (a *assembler) peek() string {
+  if len(a.toks) == 0 {
+    return ""
+  }
+  return a.toks[0].text
+}
+
+// expect reads a token that must be text.
+func (a *assembler) expect(text string) error {
+  t, err := a.next()
+  if err != nil {
+    return err
+  }
+  if t.text != text {
+    return a.errorf(t, "expected %q, found %q", text, t.text)
+  }
+  return nil
+}
+
+func (a *assembler) emit(bytes ...byte) error {
+  if a.here + len(bytes) > memorySize {
+    return a.errorf(a.stmt, "program is larger than %d bytes", memorySize)
+  }
+  copy(a.rom[a.here:], bytes)
+  a.here += len(bytes)
+  a.end = max(a.end, a.here)
+  return nil
+}
+
+// inst emits an instruction, noting its source line for the symbol map.
+func (a *assembler) inst(ops ...uint16) error {
+  if !a.recorded {
+    a.lines = append(a.lines, symbols.Line{File: a.stmt.file, Line: a.stmt.line, Addr: uint16(a.here)})
+    a.recorded = true
+  }
+  for _, op := range ops {
+    if err := a.emit(byte(op >> 8), byte(op)); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// statement assembles one instruction or directive.
+func (a *assembler) statement() error {
+  t, err := a.next()
+  if err != nil {
+    return err
+  }
+  a.stmt, a.recorded = t, false
+  switch t.text {
+    case ":":
+      name, err := a.name()
+      if err != nil {
+        return err
+      }
+      if _, ok := a.labels[name.text]; ok {
+        return a.errorf(name, "label %q is already defined", name.text)
+      }
+      a.labels[name.text] = a.here
+      return nil
+    case ":alias":
+      name, err := a.name()
+      if err != nil {
+        return err
+      }
+      r, err := a.register()
+      if err != nil {
+        return err
+      }
+      a.aliases[name.text] = r
+      return nil
+    case ":const", ":calc":
+      name, err := a.name()
+      if err != nil {
+        return err
+      }
+      var v float64
+      if t.text == ":calc" {
+        v, err = a.braced()
+      } else {
+        v, err = a.value()
+      }
+      a.consts[name.text] = v
+      return err
+    case ":macro":
+      return a.defineMacro()
+    case ":org":
+      v, err := a.value()
+      if err != nil {
+        return err
+      }
+      if v < start || v >= memorySize {
+        return a.errorf(a.last, ":org address 0x%X is outside 0x200-0xFFFF", int(v))
+      }
+      a.here = int(v)
+      return nil
+    case ":byte":
+      at := a.last
+      if len(a.toks) > 0 {
+        at = a.toks[0]
+      }
+      v, err := a.value()
+      if err != nil {
+        return err
+      }
+      if v < -128 || v > 255 {
+        return a.errorf(at, "byte %v is out of range", v)
+      }
+      return a.emit(byte(int(v)))
+    case ":unpack":
+      return a.unpack()
+    case ":call":
+      return a.address(0x2000)
+    case ":breakpoint":
+      _, err := a.next()
+      return err
+    case ":monitor":
+      if _, err := a.next(); err != nil {
+        return err
+      }
+      _, err := a.next()
+      return err
+
+    case "clear":
+      return a.inst(0x00E0)
+    case "return", ";":
+      return a.inst(0x00EE)
+    case "scroll-down", "scroll-up":
+      n, err := a.immediate(0, 15)
+      if err != nil {
+        return err
+      }
+      if t.text == "scroll-up" {
+        return a.inst(0x00D0 | uint16(n))
+      }
+      return a.inst(0x00C0 | uint16(n))
+    case "scroll-right":
+      return a.inst(0x00FB)
+    case "scroll-left":
+      return a.inst(0x00FC)
+    case "exit":
+      return a.inst(0x00FD)
+    case "lores":
+      return a.inst(0x00FE)
+    case "hires":
+      return a.inst(0x00FF)
+    case "jump":
+      return a.address(0x1000)
+    case "jump0":
+      return a.address(0xB000)
+    case "native":
+      return a.address(0x0000)
+    case "sprite":
+      x, err := a.register()
+      if err != nil {
+        return err
+      }
+      y, err := a.register()
+      if err != nil {
+        return err
+      }
+      n, err := a.immediate(0, 15)
+      if err != nil {
+        return err
+      }
+      return a.inst(0xD000 | uint16(x) << 8 | uint16(y) << 4 | uint16(n))
+    case "audio":
+      return a.inst(0xF002)
+    case "plane":
+      n, err := a.immediate(0, 3)
+      if err != nil {
+        return err
+      }
+      return a.inst(0xF001 | uint16(n) << 8)
+    case "save", "load":
+      x, err := a.register()
+      if err != nil {
+        return err
+      }
+      if a.peek() != "-" {
+        if t.text == "save" {
+          return a.inst(0xF055 | uint16(x) << 8)
+        }
+        return a.inst(0xF065 | uint16(x) << 8)
+      }
+      a.next()
+      y, err := a.register()
+      if err != nil {
+        return err
+      }
+      op := uint16(0x5002)
+      if t.text == "load" {
+        op = 0x5003
+      }
+      return a.inst(op | uint16(x) << 8 | uint16(y) << 4)
+    case "bcd", "saveflags", "loadflags":
+      x, err := a.register()
+      if err != nil {
+        return err
+      }
+      op := map[string]uint16{"bcd": 0xF033, "saveflags": 0xF075, "loadflags": 0xF085}[t.text]
+      return a.inst(op | uint16(x) << 8)
+    case "delay", "buzzer", "pitch":
+      if err := a.expect(":="); err != nil {
+        return err
+      }
+      x, err := a.register()
+      if err != nil {
+        return err
+      }
+      op := map[string]uint16{"delay": 0xF015, "buzzer": 0xF018, "pitch": 0xF03A}[t.text]
+      return a.inst(op | uint16(x) << 8)
+    case "i":
+      return a.assignI()
+
+    case "if":
+      return a.ifStatement()
+    case "else":
+      if len(a.blocks) == 0 || a.blocks[len(a.blocks) - 1].kind != "if" {
+        return a.errorf(t, "else without if ... begin")
+      }
+      b := &a.blocks[len(a.blocks) - 1]
+      skip := a.here
+      if err := a.inst(0x1000); err != nil {
+        return err
+      }
+      a.patchJump(b.addr, a.here)
+      b.kind, b.addr = "else", skip
+      return nil
+    case "end":
+      if len(a.blocks) == 0 || a.blocks[len(a.blocks) - 1].kind == "loop" {
+        return a.errorf(t, "end without if ... begin")
+      }
+      b := a.blocks[len(a.blocks) - 1]
+      a.blocks = a.blocks[:len(a.blocks) - 1]
+      a.patchJump(b.addr, a.here)
+      return nil
+    case "loop":
+      a.blocks = append(a.blocks, block{kind: "loop", at: t, addr: a.here})
+      return nil
+    case "while":
+      i := len(a.blocks) - 1
+      for i >= 0 && a.blocks[i].kind != "loop" {
+        i--
+      }
+      if i < 0 {
+        return a.errorf(t, "while outside a loop")
+      }
+      c, err := a.condition()
+      if err != nil {
+        return err
+      }
+      a.blocks[i].exits = append(a.blocks[i].exits, a.here + 2*len(c.pre) + 2)
+      return a.inst(append(c.pre, c.whenTrue, 0x1000)...)
+    case "again":
+      if len(a.blocks) == 0 || a.blocks[len(a.blocks) - 1].kind != "loop" {
+        return a.errorf(t, "again without loop")
+      }
+      b := a.blocks[len(a.blocks) - 1]
+      a.blocks = a.blocks[:len(a.blocks) - 1]
+      if err := a.inst(0x1000 | uint16(b.addr)); err != nil {
+        return err
+      }
+      for _, exit := range b.exits {
+        a.patchJump(exit, a.here)
+      }
+      return nil
+  }
+
+  if x, ok := a.reg(t.text); ok {
+    return a.assignRegister(x)
+  }
+  if m, ok := a.macros[t.text]; ok {
+    return a.expand(t, m)
+  }
+  if _, ok := a.consts[t.text]; ok {
+    return a.dataByte(t)
+  }
+  if _, ok := parseNumber(t.text); ok {
+    return a.dataByte(t)
+  }
+  if !validName(t.text) {
+    return a.errorf(t, "unexpected %q", t.text)
+  }
+  // a bare name calls it
+  a.toks = append([]token{t}, a.toks...)
+  return a.address(0x2000)
+}
+
+func (a *assembler) dataByte(t token) error {
+  v, err := a.constant(t)
+  if err != nil {
+    return err
+  }
+  if v < -128 || v > 255 {
+    return a.errorf(t, "byte %v is out of range", v)
+  }
+  return a.emit(byte(int(v)))
+}
+
+// name reads the name being defined by a label or directive.
+func (a *assembler) name() (token, error) {
+  t, err := a.next()
+  if err != nil {
+    return t, err
+  }
+  if !validName(t.text) {
+    return t, a.errorf(t, "%q can't be used as a name", t.text)
+  }
+  if _, isAlias := a.aliases[t.text]; !isAlias {
+    if _, ok := a.reg(t.text); ok {
+      return t, a.errorf(t, "%q is a register", t.text)
+    }
+  }
+  return t, nil
+}
+
+var keywords = map[string]bool{
+  ":": true, ";": true, ":=": true, "+=": true, "-=": true, "=-": true, "|=": true, "&=": true,
+  "^=": true, ">>=": true, "<<=": true, "==": true, "!=": true, "<": true, ">": true, "<=": true,
+  ">=": true, "-": true, "{": true, "}": true, "key": true, "-key": true, "hex": true,
+  "bighex": true, "random": true, "long": true, "delay": true, "buzzer": true, "pitch": true,
+  "i": true, "if": true, "then": true, "begin": true, "else": true, "end": true, "loop": true,
+  "again": true, "while": true, "clear": true, "return": true, "exit": true, "lores": true,
+  "hires": true, "jump": true, "jump0": true, "native": true, "sprite": true, "audio": true,
+  "plane": true, "save": true, "load": true, "saveflags": true, "loadflags": true, "bcd": true,
+  "scroll-up": true, "scroll-down": true, "scroll-left": true, "scroll-right": true,
+}
+
+func validName(s string) bool {
+  if s == "" || keywords[s] || strings.HasPrefix(s, ":") {
+    return false
+  }
+  _, isNumber := parseNumber(s)
+  return !isNumber
+}
+
+// parseNumber reads decimal, 0x hex and 0b binary numbers, which may be
+// negative.
+// Unlike Go, a leading 0 is still decimal and there are no underscores.
+func parseNumber(s string) (int, bool) {
+  digits, neg := strings.CutPrefix(s, "-")
+  base := 10
+  switch {
+    case strings.HasPrefix(digits, "0x"), strings.HasPrefix(digits, "0X"):
+      base, digits = 16, digits[2:]
+    case strings.HasPrefix(digits, "0b"), strings.HasPrefix(digits, "0B"):
+      base, digits = 2, digits[2:]
+  }
+  if digits == "" || strings.ContainsAny(digits, "_+-") {
+    return 0, false
+  }
+  n, err := strconv.ParseInt(digits, base, 32)
+  if neg {
+    n = -n
+  }
+  return int(n), err == nil
+}
+
+// reg recognizes v0 to vF and register aliases.
+func (a *assembler) reg(s string) (uint8, bool) {
+  if r, ok := a.aliases[s]; ok {
+    return r, true
+  }
+  if len(s) == 2 && (s[0] == 'v' || s[0] == 'V') {
+    if n, err := strconv.ParseUint(s[1:], 16, 8); err == nil {
+      return uint8(n), true
+    }
+  }
+  return 0, false
+}
+
+func (a *assembler) register() (uint8, error) {
+  t, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  r, ok := a.reg(t.text)
+  if !ok {
+    return 0, a.errorf(t, "expected a register, found %q", t.text)
+  }
+  return r, nil
+}
+
+// value reads a number, a constant or a { calc } expression.
+func (a *assembler) value() (float64, error) {
+  if a.peek() == "{" {
+    return a.braced()
+  }
+  t, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  return a.constant(t)
+}
+
+// braced evaluates a { ... } expression.
+func (a *assembler) braced() (float64, error) {
+  open, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  if open.text != "{" {
+    return 0, a.errorf(open, "expected {, found %q", open.text)
+  }
+  for i, t := range a.toks {
+    if t.text == "}" {
+      expr := a.toks[:i]
+      a.last, a.toks = t, a.toks[i + 1:]
+      return a.calc(expr)
+    }
+  }
+  return 0, a.errorf(open, "missing }")
+}
+
+// immediate reads an integer constant between lo and hi.
+func (a *assembler) immediate(lo, hi int) (int, error) {
+  t, err := a.next()
+  if err != nil {
+    return 0, err
+  }
+  v, err := a.constant(t)
+  if err != nil {
+    return 0, err
+  }
+  n := int(math.Floor(v))
+  if n < lo || n > hi {
+    return 0, a.errorf(t, "%s is out of range %d to %d", t.text, lo, hi)
+  }
+  return n, nil
+}
+
+// byteValue reads an immediate byte, which may be written as -128 to -1.
+func (a *assembler) byteValue() (uint16, error) {
+  n, err := a.immediate(-128, 255)
+  return uint16(n) & 0xFF, err
+}
+
+// address reads an address and emits op with it in the low 12 bits.
+func (a *assembler) address(op uint16) error {
+  t, err := a.next()
+  if err != nil {
+    return err
+  }
+  return a.jumpTo(op, t)
+}
+
+// jumpTo emits op with the address named by t, patching it later if t is
+// a label that hasn't been defined yet.
+func (a *assembler) jumpTo(op uint16, t token) error {
+  at := a.here
+  patch := func(v int) error {
+    if v < 0 || v > 0xFFF {
+      return a.errorf(t, "address 0x%X of %s is out of range for a 12-bit operand", v, t.text)
+    }
+    a.rom[at] = byte(op >> 8) | byte(v >> 8)
+    a.rom[at + 1] = byte(v)
+    return nil
+  }
+  if err := a.inst(op); err != nil {
+    return err
+  }
+  return a.resolve(t, patch)
+}
+
+// resolve calls patch with the value of t now, or once all labels are
+// known.
+func (a *assembler) resolve(t token, patch func(v int) error) error {
+  if v, err := a.constant(t); err == nil {
+    return patch(int(v))
+  }
+  if !validName(t.text) {
+    return a.errorf(t, "expected an address, found %q", t.text)
+  }
+  a.fixups = append(a.fixups, fixup{t, patch})
+  return nil
+}
+
+// patchJump points the jump at addr to target.
+func (a *assembler) patchJump(addr, target int) {
+  a.rom[addr] = a.rom[addr] & 0xF0 | byte(target >> 8) & 0x0F
+  a.rom[addr + 1] = byte(target)
+}
+
+func (a *assembler) assignI() error {
+  op, err := a.next()
+  if err != nil {
+    return err
+  }
+  switch op.text {
+    case ":=":
+      t, err := a.next()
+      if err != nil {
+        return err
+      }
+      switch t.text {
+        case "hex", "bighex":
+          x, err := a.register()
+          if err != nil {
+            return err
+          }
+          if t.text == "hex" {
+            return a.inst(0xF029 | uint16(x) << 8)
+          }
+          return a.inst(0xF030 | uint16(x) << 8)
+        case "long":
+          target, err := a.next()
+          if err != nil {
+            return err
+          }
+          at := a.here + 2
+          if err := a.inst(0xF000, 0x0000); err != nil {
+            return err
+          }
+          return a.resolve(target, func(v int) error {
+            if v < 0 || v > 0xFFFF {
+              return a.errorf(target, "address 0x%X of %s is out of range", v, target.text)
+            }
+            a.rom[at], a.rom[at + 1] = byte(v >> 8), byte(v)
+            return nil
+          })
+      }
+      return a.jumpTo(0xA000, t)
+    case "+=":
+      x, err := a.register()
+      if err != nil {
+        return err
+      }
+      return a.inst(0xF01E | uint16(x) << 8)
+  }
+  return a.errorf(op, "expected := or += after i, found %q", op.text)
+}
+
+var aluOps = map[string]uint16{
+  ":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE,
+}
+
+func (a *assembler) assignRegister(x uint8) error {
+  op, err := a.next()
+  if err != nil {
+    return err
+  }
+  alu, ok := aluOps[op.text]
+  if !ok {
+    return a.errorf(op, "unknown operator %q", op.text)
+  }
+  vx := uint16(x) << 8
+  if y, ok := a.reg(a.peek()); ok {
+    a.next()
+    return a.inst(0x8000 | vx | uint16(y) << 4 | alu)
+  }
+  switch {
+    case op.text == ":=" && a.peek() == "random":
+      a.next()
+      n, err := a.byteValue()
+      if err != nil {
+        return err
+      }
+      return a.inst(0xC000 | vx | n)
+    case op.text == ":=" && a.peek() == "key":
+      a.next()
+      return a.inst(0xF00A | vx)
+    case op.text == ":=" && a.peek() == "delay":
+      a.next()
+      return a.inst(0xF007 | vx)
+    case op.text == ":=":
+      n, err := a.byteValue()
+      if err != nil {
+        return err
+      }
+      return a.inst(0x6000 | vx | n)
+    case op.text == "+=":
+      n, err := a.byteValue()
+      if err != nil {
+        return err
+      }
+      return a.inst(0x7000 | vx | n)
+    case op.text == "-=":
+      // there is no subtract immediate, so add the negation
+      n, err := a.byteValue()
+      if err != nil {
+        return err
+      }
+      return a.inst(0x7000 | vx | -n & 0xFF)
+  }
+  t, _ := a.next()
+  return a.errorf(t, "expected a register after %s, found %q", op.text, t.text)
+}
+
+// cond is a compiled condition: instructions to run first, and the skips
+// that step over the next instruction when the condition is false or true.
+type cond struct {
+  pre       []uint16
+  whenFalse uint16
+  whenTrue  uint16
+}
+
+// operand is a register or an immediate byte in a comparison.
+type operand struct {
+  reg bool
+  v   uint16
+}
+
+func (a *assembler) condition() (cond, error) {
+  x, err := a.register()
+  if err != nil {
+    return cond{}, err
+  }
+  vx := uint16(x) << 8
+  op, err := a.next()
+  if err != nil {
+    return cond{}, err
+  }
+  switch op.text {
+    case "key":
+      return cond{whenFalse: 0xE0A1 | vx, whenTrue: 0xE09E | vx}, nil
+    case "-key":
+      return cond{whenFalse: 0xE09E | vx, whenTrue: 0xE0A1 | vx}, nil
+  }
+
+  var rhs operand
+  if y, ok := a.reg(a.peek()); ok {
+    a.next()
+    rhs = operand{true, uint16(y)}
+  } else if rhs.v, err = a.byteValue(); err != nil {
+    return cond{}, err
+  }
+  lhs := operand{true, uint16(x)}
+  switch op.text {
+    case "==", "!=":
+      se, sne := 0x3000 | vx | rhs.v, 0x4000 | vx | rhs.v
+      if rhs.reg {
+        se, sne = 0x5000 | vx | rhs.v << 4, 0x9000 | vx | rhs.v << 4
+      }
+      if op.text == "==" {
+        return cond{whenFalse: sne, whenTrue: se}, nil
+      }
+      return cond{whenFalse: se, whenTrue: sne}, nil
+    // the rest compare with a subtraction in vF, whose flag is 1 when
+    // there is no borrow
+    case ">=":
+      return flagIs(1, atLeast(lhs, rhs)), nil
+    case "<":
+      return flagIs(0, atLeast(lhs, rhs)), nil
+    case "<=":
+      return flagIs(1, atLeast(rhs, lhs)), nil
+    case ">":
+      return flagIs(0, atLeast(rhs, lhs)), nil
+  }
+  return cond{}, a.errorf(op, "unknown comparison %q", op.text)
+}
+
+// atLeast sets vF to 1 if p >= q and 0 otherwise. At least one of them is a
+// register.
+func atLeast(p, q operand) []uint16 {
+  switch {
+    case !q.reg:
+      return []uint16{0x6F00 | q.v, 0x8F07 | p.v << 4}
+    case !p.reg:
+      return []uint16{0x6F00 | p.v, 0x8F05 | q.v << 4}
+  }
+  return []uint16{0x8F00 | p.v << 4, 0x8F05 | q.v << 4}
+}
+
+func flagIs(v uint16, pre []uint16) cond {
+  return cond{pre: pre, whenFalse: 0x4F00 | v, whenTrue: 0x3F00 | v}
+}
+
+func (a *assembler) ifStatement() error {
+  t := a.stmt
+  c, err := a.condition()
+  if err != nil {
+    return err
+  }
+  kw, err := a.next()
+  if err != nil {
+    return err
+  }
+  switch kw.text {
+    case "then":
+      return a.inst(append(c.pre, c.whenFalse)...)
+    case "begin":
+      a.blocks = append(a.blocks, block{kind: "if", at: t, addr: a.here + 2*len(c.pre) + 2})
+      return a.inst(append(c.pre, c.whenTrue, 0x1000)...)
+  }
+  return a.errorf(kw, "expected then or begin, found %q", kw.text)
+}
+
+// unpack assembles :unpack n label, which loads v0 with n in the high
+// nibble and the top of label's address, and v1 with the bottom byte.
+// :unpack long label loads the whole 16-bit address.
+func (a *assembler) unpack() error {
+  var hi uint16
+  if a.peek() == "long" {
+    a.next()
+  } else {
+    n, err := a.immediate(0, 15)
+    if err != nil {
+      return err
+    }
+    hi = uint16(n) << 4
+  }
+  target, err := a.next()
+  if err != nil {
+    return err
+  }
+  at := a.here
+  if err := a.inst(0x6000 | hi, 0x6100); err != nil {
+    return err
+  }
+  return a.resolve(target, func(v int) error {
+    if v < 0 || v > 0xFFFF || (hi != 0 && v > 0xFFF) {
+      return a.errorf(target, "address 0x%X of %s is out of range", v, target.text)
+    }
+    a.rom[at + 1] |= byte(v >> 8)
+    a.rom[at + 3] = byte(v)
+    return nil
+  })
+}
+
+// defineMacro reads :macro name args { body }.
+func (a *assembler) defineMacro() error {
+  name, err := a.name()
+  if err != nil {
+    return err
+  }
+  m := &macro{}
+  for {
+    t, err := a.next()
+    if err != nil {
+      return err
+    }
+    if t.text == "{" {
+      break
+    }
+    m.args = append(m.args, t.text)
+  }
+  depth := 1
+  for depth > 0 {
+    t, err := a.next()
+    if err != nil {
+      return a.errorf(name, "macro %s is missing }", name.text)
+    }
+    switch t.text {
+      case "{":
+        depth++
+      case "}":
+        depth--
+        if depth == 0 {
+          continue
+        }
+    }
+    m.body = append(m.body, t)
+  }
+  a.macros[name.text] = m
+  return nil
+}
+
+// expand replaces a macro invocation with the macro's body, its arguments
+// substituted.
+func (a *assembler) expand(t token, m *macro) error {
+  a.expansions++
+  if a.expansions > maxExpansions {
+    return a.errorf(t, "too many macro expansions; does %s expand itself?", t.text)
+  }
+  args := map[string]string{}
+  for _, name := range m.args {
+    arg, err := a.next()
+    if err != nil {
+      return err
+    }
+    args[name] = arg.text
+  }
+  body := make([]token, len(m.body), len(m.body) + len(a.toks))
+  for i, b := range m.body {
+    if arg, ok := args[b.text]; ok {
+      b.text = arg
+    }
+    body[i] = b
+  }
+  a.toks = append(body, a.toks...)
+  return nil
+}
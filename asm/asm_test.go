@@ -0,0 +1,192 @@
+package asm
+
+import (
+  "bytes"
+  "errors"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+func assemble(t *testing.T, src string) []byte {
+  t.Helper()
+  p, err := Assemble("test.8o", []byte(src))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return p.ROM
+}
+
+func checkROM(t *testing.T, src string, want []byte) {
+  t.Helper()
+  if got := assemble(t, src); !bytes.Equal(got, want) {
+    t.Errorf("Incorrect ROM for %q. Got % X, wanted % X", src, got, want)
+  }
+}
+
+func TestInstructions(t *testing.T) {
+  for _, tt := range []struct {
+    src  string
+    want []byte
+  }{
+    {": main clear return ; exit lores hires", []byte{0x00, 0xE0, 0x00, 0xEE, 0x00, 0xEE, 0x00, 0xFD, 0x00, 0xFE, 0x00, 0xFF}},
+    {": main scroll-down 3 scroll-up 0xA scroll-left scroll-right", []byte{0x00, 0xC3, 0x00, 0xDA, 0x00, 0xFC, 0x00, 0xFB}},
+    {": main v3 := 0x42 v3 += 1 v3 -= 1 v3 := -1", []byte{0x63, 0x42, 0x73, 0x01, 0x73, 0xFF, 0x63, 0xFF}},
+    {": main v1 := v2 v1 |= v2 v1 &= v2 v1 ^= v2 v1 += v2 v1 -= v2 v1 >>= v2 v1 =- v2 v1 <<= v2", []byte{
+      0x81, 0x20, 0x81, 0x21, 0x81, 0x22, 0x81, 0x23, 0x81, 0x24, 0x81, 0x25, 0x81, 0x26, 0x81, 0x27, 0x81, 0x2E,
+    }},
+    {": main vA := random 0x0F vA := key vA := delay delay := vA buzzer := vA pitch := vA", []byte{
+      0xCA, 0x0F, 0xFA, 0x0A, 0xFA, 0x07, 0xFA, 0x15, 0xFA, 0x18, 0xFA, 0x3A,
+    }},
+    {": main i := 0x123 i += v4 i := hex v4 i := bighex v4 bcd v4 save v4 load v4", []byte{
+      0xA1, 0x23, 0xF4, 0x1E, 0xF4, 0x29, 0xF4, 0x30, 0xF4, 0x33, 0xF4, 0x55, 0xF4, 0x65,
+    }},
+    {": main saveflags v7 loadflags v7 save v1 - v3 load v3 - v1", []byte{0xF7, 0x75, 0xF7, 0x85, 0x51, 0x32, 0x53, 0x13}},
+    {": main sprite v1 v2 0 plane 3 audio i := long 0xBEEF jump0 0x300", []byte{
+      0xD1, 0x20, 0xF3, 0x01, 0xF0, 0x02, 0xF0, 0x00, 0xBE, 0xEF, 0xB3, 0x00,
+    }},
+    {": main if v1 == 2 then if v1 != v2 then if v1 key then if v1 -key then", []byte{
+      0x41, 0x02, 0x51, 0x20, 0xE1, 0xA1, 0xE1, 0x9E,
+    }},
+    {": main 0x12 0b101 7 -1", []byte{0x12, 0x05, 0x07, 0xFF}},
+    // a leading zero is decimal in Octo, not octal
+    {": main v0 := 010 v1 := -010 0x0A 0b0011", []byte{0x60, 0x0A, 0x61, 0xF6, 0x0A, 0x03}},
+  } {
+    checkROM(t, tt.src, tt.want)
+  }
+}
+
+func TestLabels(t *testing.T) {
+  checkROM(t, `
+: main
+  sub        # forward call
+  jump main
+: sub
+  i := data
+  return
+: data
+  0xFF
+`, []byte{0x22, 0x04, 0x12, 0x00, 0xA2, 0x08, 0x00, 0xEE, 0xFF})
+
+  // main later in the source gets a jump at 0x200
+  checkROM(t, ": sub return : main sub", []byte{0x12, 0x04, 0x00, 0xEE, 0x22, 0x02})
+}
+
+func TestDirectives(t *testing.T) {
+  checkROM(t, `
+:alias x v3
+:const SPEED 4
+:calc DOUBLE { SPEED * 2 + 1 }
+: main
+  x := SPEED
+  x += DOUBLE
+  :byte { 1 << 4 }
+  :unpack 0xA data
+: data
+`, []byte{0x63, 0x04, 0x73, 0x0C, 0x10, 0x60, 0xA2, 0x61, 0x09})
+
+  checkROM(t, `
+:macro twice op { op op }
+:macro bump reg n { reg += n }
+: main
+  twice clear
+  bump v2 3
+`, []byte{0x00, 0xE0, 0x00, 0xE0, 0x72, 0x03})
+
+  checkROM(t, ": main exit :org 0x204 0x11", []byte{0x00, 0xFD, 0x00, 0x00, 0x11})
+}
+
+func TestControlFlow(t *testing.T) {
+  checkROM(t, `
+: main
+  if v0 == 1 begin
+    v1 := 1
+  else
+    v1 := 2
+  end
+  loop
+    v2 += 1
+    while v2 != 5
+    v3 += 1
+  again
+`, []byte{
+    0x30, 0x01, 0x12, 0x08, /* 200: if v0 == 1 begin */
+    0x61, 0x01, 0x12, 0x0A, /* 204: v1 := 1 else */
+    0x61, 0x02, /* 208: v1 := 2 end */
+    0x72, 0x01, /* 20A: loop v2 += 1 */
+    0x42, 0x05, 0x12, 0x14, /* 20C: while v2 != 5 */
+    0x73, 0x01, 0x12, 0x0A, /* 210: v3 += 1 again */
+  })
+}
+
+// TestComparisons runs the vF based comparisons on the CPU.
+func TestComparisons(t *testing.T) {
+  for _, tt := range []struct {
+    cmp  string
+    want bool
+  }{
+    {"v0 < v1", true}, {"v1 < v0", false}, {"v0 < v0", false},
+    {"v0 > 2", true}, {"v1 > 9", false}, {"v0 <= 3", true},
+    {"v0 <= 2", false}, {"v1 >= 9", true}, {"4 <= v0", false},
+  } {
+    src := ": main v0 := 3 v1 := 9 v2 := 0 if " + tt.cmp + " then v2 := 1 exit"
+    p, err := Assemble("test.8o", []byte(src))
+    if tt.cmp == "4 <= v0" {
+      // the left side must be a register
+      if err == nil {
+        t.Errorf("Expected an error for %q", tt.cmp)
+      }
+      continue
+    }
+    if err != nil {
+      t.Fatal(err)
+    }
+    c := cpu.NewCPU(cpu.WithPlatform(cpu.PlatformSCHIP))
+    c.LoadRom(p.ROM)
+    for i := 0; i < 10; i++ {
+      c.RunCycle()
+    }
+    if got := c.Registers().V[2] == 1; got != tt.want {
+      t.Errorf("Incorrect result of %v. Got %v, wanted %v", tt.cmp, got, tt.want)
+    }
+  }
+}
+
+func TestSymbols(t *testing.T) {
+  p, err := Assemble("game.8o", []byte(": main\n  v0 := 1\n\n: top\n  jump top\n"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if p.Symbols.Labels["top"] != 0x202 {
+    t.Errorf("Incorrect label address 0x%03X", p.Symbols.Labels["top"])
+  }
+  if l, ok := p.Symbols.Source(0x202); !ok || l.File != "game.8o" || l.Line != 5 {
+    t.Errorf("Incorrect source for 0x202: %+v", l)
+  }
+}
+
+func TestErrors(t *testing.T) {
+  for _, tt := range []struct {
+    src  string
+    want string
+  }{
+    {": main\n  v0 := 256", "test.8o:2:9: 256 is out of range -128 to 255"},
+    {": main\n  jump nowhere", "test.8o:2:8: undefined name \"nowhere\""},
+    {": main\n  if v0 == 1 begin\n", "test.8o:2:3: if is never closed"},
+    {": main\n  again", "test.8o:2:3: again without loop"},
+    {": main\n: main", "test.8o:2:3: label \"main\" is already defined"},
+    {": main\n  v0 <> v1", "test.8o:2:6: unknown operator \"<>\""},
+    {": main\n  :calc X { 1 + }", "test.8o:2:17: expression ends early"},
+    {": main\n  v0 := 0o17", "test.8o:2:9: undefined name \"0o17\""},
+    {": main\n  v0 := 1_0", "test.8o:2:9: undefined name \"1_0\""},
+    {": start\n  jump start", "test.8o:1:1: program has no main label"},
+    {": main\n  :byte 300", "test.8o:2:9: byte 300 is out of range"},
+    {": main\n  :byte { 0 - 129 }", "test.8o:2:9: byte -129 is out of range"},
+  } {
+    _, err := Assemble("test.8o", []byte(tt.src))
+    var e *Error
+    if !errors.As(err, &e) || err.Error() != tt.want {
+      t.Errorf("Incorrect error for %q. Got %v, wanted %v", tt.src, err, tt.want)
+    }
+  }
+}
@@ -0,0 +1,141 @@
+package asm
+
+import (
+  "math"
+)
+
+// calc evaluates the tokens of a :calc or :byte expression. As in Octo,
+// there is no precedence: binary operators are applied right to left, so
+// 2 * 3 + 4 is 14. Parentheses group.
+func (a *assembler) calc(toks []token) (float64, error) {
+  if len(toks) == 0 {
+    return 0, a.errorf(a.last, "empty expression")
+  }
+  v, rest, err := a.expr(toks)
+  if err != nil {
+    return 0, err
+  }
+  if len(rest) > 0 {
+    return 0, a.errorf(rest[0], "unexpected %q in expression", rest[0].text)
+  }
+  return v, nil
+}
+
+var binary = map[string]func(a, b float64) float64{
+  "+":   func(a, b float64) float64 { return a + b },
+  "-":   func(a, b float64) float64 { return a - b },
+  "*":   func(a, b float64) float64 { return a * b },
+  "/":   func(a, b float64) float64 { return a / b },
+  "%":   func(a, b float64) float64 { return math.Mod(a, b) },
+  "&":   func(a, b float64) float64 { return float64(int64(a) & int64(b)) },
+  "|":   func(a, b float64) float64 { return float64(int64(a) | int64(b)) },
+  "^":   func(a, b float64) float64 { return float64(int64(a) ^ int64(b)) },
+  "<<":  func(a, b float64) float64 { return float64(int64(a) << uint(b)) },
+  ">>":  func(a, b float64) float64 { return float64(int64(a) >> uint(b)) },
+  "pow": math.Pow,
+  "min": math.Min,
+  "max": math.Max,
+  "<":   func(a, b float64) float64 { return bool64(a < b) },
+  ">":   func(a, b float64) float64 { return bool64(a > b) },
+  "<=":  func(a, b float64) float64 { return bool64(a <= b) },
+  ">=":  func(a, b float64) float64 { return bool64(a >= b) },
+  "==":  func(a, b float64) float64 { return bool64(a == b) },
+  "!=":  func(a, b float64) float64 { return bool64(a != b) },
+}
+
+var unary = map[string]func(a float64) float64{
+  "-":     func(a float64) float64 { return -a },
+  "~":     func(a float64) float64 { return float64(^int64(a)) },
+  "!":     func(a float64) float64 { return bool64(a == 0) },
+  "abs":   math.Abs,
+  "sqrt":  math.Sqrt,
+  "sin":   math.Sin,
+  "cos":   math.Cos,
+  "tan":   math.Tan,
+  "exp":   math.Exp,
+  "log":   math.Log,
+  "floor": math.Floor,
+  "ceil":  math.Ceil,
+  "sign":  func(a float64) float64 { return bool64(a > 0) - bool64(a < 0) },
+}
+
+func bool64(b bool) float64 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+func (a *assembler) expr(toks []token) (float64, []token, error) {
+  left, rest, err := a.term(toks)
+  if err != nil {
+    return 0, nil, err
+  }
+  if len(rest) == 0 || rest[0].text == ")" {
+    return left, rest, nil
+  }
+  op, ok := binary[rest[0].text]
+  if !ok {
+    return 0, nil, a.errorf(rest[0], "unknown operator %q", rest[0].text)
+  }
+  right, rest, err := a.expr(rest[1:])
+  if err != nil {
+    return 0, nil, err
+  }
+  return op(left, right), rest, nil
+}
+
+func (a *assembler) term(toks []token) (float64, []token, error) {
+  if len(toks) == 0 {
+    return 0, nil, a.errorf(a.last, "expression ends early")
+  }
+  t := toks[0]
+  if t.text == "(" {
+    v, rest, err := a.expr(toks[1:])
+    if err != nil {
+      return 0, nil, err
+    }
+    if len(rest) == 0 || rest[0].text != ")" {
+      return 0, nil, a.errorf(t, "missing )")
+    }
+    return v, rest[1:], nil
+  }
+  if op, ok := unary[t.text]; ok {
+    v, rest, err := a.term(toks[1:])
+    if err != nil {
+      return 0, nil, err
+    }
+    return op(v), rest, nil
+  }
+  if t.text == "@" {
+    v, rest, err := a.term(toks[1:])
+    if err != nil {
+      return 0, nil, err
+    }
+    addr := int(v)
+    if addr < 0 || addr >= len(a.rom) {
+      return 0, nil, a.errorf(t, "address %d is out of range", addr)
+    }
+    return float64(a.rom[addr]), rest, nil
+  }
+  v, err := a.constant(t)
+  return v, toks[1:], err
+}
+
+// constant is the value of a number, :const, :calc or label that has
+// already been defined, or HERE for the current address.
+func (a *assembler) constant(t token) (float64, error) {
+  if n, ok := parseNumber(t.text); ok {
+    return float64(n), nil
+  }
+  if t.text == "HERE" {
+    return float64(a.here), nil
+  }
+  if v, ok := a.consts[t.text]; ok {
+    return v, nil
+  }
+  if v, ok := a.labels[t.text]; ok {
+    return float64(v), nil
+  }
+  return 0, a.errorf(t, "undefined name %q", t.text)
+}
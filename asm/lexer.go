@@ -0,0 +1,40 @@
+package asm
+
+import (
+  "strings"
+)
+
+// token is one whitespace separated word of source, with where it came
+// from for error messages. Columns are 1-based byte offsets.
+type token struct {
+  text string
+  file string
+  line int
+  col  int
+}
+
+// tokenize splits Octo source into tokens. Like Octo, tokens are only
+// separated by whitespace and # starts a comment that runs to the end of
+// the line.
+func tokenize(file string, src string) []token {
+  var toks []token
+  for n, line := range strings.Split(src, "\n") {
+    for col := 0; col < len(line); {
+      c := line[col]
+      if c == '#' {
+        break
+      }
+      if c == ' ' || c == '\t' || c == '\r' {
+        col++
+        continue
+      }
+      end := col
+      for end < len(line) && line[end] != ' ' && line[end] != '\t' && line[end] != '\r' {
+        end++
+      }
+      toks = append(toks, token{line[col:end], file, n + 1, col + 1})
+      col = end
+    }
+  }
+  return toks
+}
@@ -0,0 +1,145 @@
+// Package cartridge reads Octo cartridges: GIF images with a program and
+// its settings hidden in the pixels.
+//
+// The payload is carried in the low nibble of each pixel's palette index,
+// two pixels to a byte with the high nibble first, reading every frame in
+// order. It is a 32-bit big-endian length followed by that many bytes of
+// JSON holding the Octo source of the program and its options.
+package cartridge
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "image/color"
+  "image/gif"
+  "io"
+  "strconv"
+  "strings"
+
+  "cryp-8/asm"
+  "cryp-8/cpu"
+)
+
+// ErrNotCartridge is returned by Decode for a GIF without a payload.
+var ErrNotCartridge = errors.New("cartridge: GIF has no Octo cartridge payload")
+
+// Options are the settings Octo saves with a program, named as in Octo.
+// Octo's vfOrderQuirks has no equivalent here and is ignored.
+type Options struct {
+  TickRate        int    `json:"tickrate"`
+  BackgroundColor string `json:"backgroundColor"`
+  FillColor       string `json:"fillColor"`
+  FillColor2      string `json:"fillColor2"`
+  BlendColor      string `json:"blendColor"`
+  ShiftQuirks     bool   `json:"shiftQuirks"`
+  LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+  JumpQuirks      bool   `json:"jumpQuirks"`
+  LogicQuirks     bool   `json:"logicQuirks"`
+  ClipQuirks      bool   `json:"clipQuirks"`
+  VBlankQuirks    bool   `json:"vBlankQuirks"`
+  MaxSize         int    `json:"maxSize"`
+}
+
+// Cartridge is the contents of a cartridge.
+type Cartridge struct {
+  Program string  `json:"program"`
+  Options Options `json:"options"`
+}
+
+// Decode reads a cartridge GIF.
+func Decode(r io.Reader) (*Cartridge, error) {
+  g, err := gif.DecodeAll(r)
+  if err != nil {
+    return nil, err
+  }
+  var nibbles []byte
+  for _, frame := range g.Image {
+    b := frame.Bounds()
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+      for x := b.Min.X; x < b.Max.X; x++ {
+        nibbles = append(nibbles, frame.ColorIndexAt(x, y) & 0xF)
+      }
+    }
+  }
+  data := make([]byte, len(nibbles)/2)
+  for j := range data {
+    data[j] = nibbles[2*j] << 4 | nibbles[2*j + 1]
+  }
+  if len(data) < 4 {
+    return nil, ErrNotCartridge
+  }
+  size := int(data[0]) << 24 | int(data[1]) << 16 | int(data[2]) << 8 | int(data[3])
+  if size > len(data) - 4 {
+    return nil, ErrNotCartridge
+  }
+  c := &Cartridge{}
+  if err := json.Unmarshal(data[4:4 + size], c); err != nil {
+    return nil, ErrNotCartridge
+  }
+  return c, nil
+}
+
+// ROM assembles the program. name is used in error messages.
+func (c *Cartridge) ROM(name string) ([]byte, error) {
+  p, err := asm.Assemble(name, []byte(c.Program))
+  if err != nil {
+    return nil, err
+  }
+  return p.ROM, nil
+}
+
+// Platform is the instruction set implied by the memory size Octo was set
+// to.
+func (o Options) Platform() cpu.Platform {
+  switch {
+    case o.MaxSize != 0 && o.MaxSize <= 3232:
+      return cpu.PlatformCHIP8
+    case o.MaxSize != 0 && o.MaxSize <= 3583:
+      return cpu.PlatformSCHIP
+  }
+  return cpu.PlatformXOCHIP
+}
+
+// Quirks translates Octo's quirk settings. Octo's load/store quirk is the
+// one where I is left alone.
+func (o Options) Quirks() cpu.Quirks {
+  return cpu.Quirks{
+    ShiftVX:     o.ShiftQuirks,
+    IncrementI:  !o.LoadStoreQuirks,
+    JumpVX:      o.JumpQuirks,
+    ResetVF:     o.LogicQuirks,
+    ClipSprites: o.ClipQuirks,
+    DisplayWait: o.VBlankQuirks,
+  }
+}
+
+// Octo's default colors, used for any the cartridge leaves out.
+var defaultColors = [4]string{"#996600", "#FFCC00", "#FF6600", "#662200"}
+
+// Palette colors the four values cpu.Colors returns: the background, plane
+// 0, plane 1 and both planes.
+func (o Options) Palette() color.Palette {
+  p := make(color.Palette, 4)
+  for j, s := range [4]string{o.BackgroundColor, o.FillColor, o.FillColor2, o.BlendColor} {
+    c, err := parseColor(s)
+    if err != nil {
+      c, _ = parseColor(defaultColors[j])
+    }
+    p[j] = c
+  }
+  return p
+}
+
+// parseColor reads a #RRGGBB color.
+func parseColor(s string) (color.RGBA, error) {
+  hex := strings.TrimPrefix(s, "#")
+  if len(hex) != 6 {
+    return color.RGBA{}, fmt.Errorf("cartridge: bad color %q", s)
+  }
+  v, err := strconv.ParseUint(hex, 16, 32)
+  if err != nil {
+    return color.RGBA{}, fmt.Errorf("cartridge: bad color %q", s)
+  }
+  return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
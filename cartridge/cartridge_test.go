@@ -0,0 +1,101 @@
+package cartridge
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "image"
+  "image/color"
+  "image/gif"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+// makeGIF hides payload in the pixels of a two frame GIF the way Octo
+// does.
+func makeGIF(t *testing.T, payload []byte) []byte {
+  data := append([]byte{byte(len(payload) >> 24), byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload))}, payload...)
+  var nibbles []uint8
+  for _, b := range data {
+    nibbles = append(nibbles, b >> 4, b & 0xF)
+  }
+  // 32 grays, so the high bit of each index is label art, not data
+  palette := make(color.Palette, 32)
+  for j := range palette {
+    palette[j] = color.Gray{uint8(j*8)}
+  }
+  const w, h = 32, 16
+  g := &gif.GIF{}
+  for len(nibbles) > 0 || len(g.Image) < 2 {
+    frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+    for j := range frame.Pix {
+      frame.Pix[j] = uint8(j % 2) << 4
+      if j < len(nibbles) {
+        frame.Pix[j] |= nibbles[j]
+      }
+    }
+    nibbles = nibbles[min(len(nibbles), w*h):]
+    g.Image = append(g.Image, frame)
+    g.Delay = append(g.Delay, 0)
+  }
+  var out bytes.Buffer
+  if err := gif.EncodeAll(&out, g); err != nil {
+    t.Fatal(err)
+  }
+  return out.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+  src := ": main\n  v0 := 7\n  loop again\n"
+  // long enough to spill into the second frame
+  for len(src) < 400 {
+    src += "# padding\n"
+  }
+  payload, _ := json.Marshal(map[string]any{
+    "program": src,
+    "options": map[string]any{
+      "tickrate": 30, "fillColor": "#FF0000", "backgroundColor": "#000080",
+      "shiftQuirks": true, "loadStoreQuirks": true, "maxSize": 3583,
+    },
+  })
+  c, err := Decode(bytes.NewReader(makeGIF(t, payload)))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if c.Program != src || c.Options.TickRate != 30 {
+    t.Errorf("Incorrect cartridge %+v", c)
+  }
+  rom, err := c.ROM("game.gif")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(rom, []byte{0x60, 0x07, 0x12, 0x02}) {
+    t.Errorf("Incorrect ROM % X", rom)
+  }
+
+  o := c.Options
+  if o.Platform() != cpu.PlatformSCHIP {
+    t.Errorf("Incorrect platform %v", o.Platform())
+  }
+  if q := o.Quirks(); q != (cpu.Quirks{ShiftVX: true}) {
+    t.Errorf("Incorrect quirks %+v", q)
+  }
+  p := o.Palette()
+  if p[0] != (color.RGBA{0, 0, 0x80, 0xff}) || p[1] != (color.RGBA{0xff, 0, 0, 0xff}) {
+    t.Errorf("Incorrect colors %v", p)
+  }
+  // fillColor2 was left out, so Octo's default is used
+  if p[2] != (color.RGBA{0xff, 0x66, 0x00, 0xff}) {
+    t.Errorf("Incorrect default color %v", p[2])
+  }
+}
+
+func TestNotCartridge(t *testing.T) {
+  if _, err := Decode(bytes.NewReader(makeGIF(t, []byte("not json")))); !errors.Is(err, ErrNotCartridge) {
+    t.Errorf("Expected ErrNotCartridge, got %v", err)
+  }
+  if _, err := Decode(bytes.NewReader([]byte("GIF89a"))); err == nil {
+    t.Errorf("Expected an error for a broken GIF")
+  }
+}
@@ -0,0 +1,213 @@
+package cpu
+
+import (
+  "context"
+)
+
+// maxBlockLength bounds how many instructions are compiled into one block.
+const maxBlockLength = 64
+
+// op is one pre-decoded instruction. Like execute, it leaves the PC on the
+// next instruction to run.
+type op func(cpu *CPU) error
+
+// block is a straight-line run of instructions starting at start and
+// covering the bytes up to end. Only its last instruction may branch.
+type block struct {
+  start uint16
+  end   int
+  ops   []op
+}
+
+// blockCache holds compiled blocks by start address. code marks every byte
+// that belongs to some block so writes to it can drop the cache.
+type blockCache struct {
+  blocks     []*block
+  code       []bool
+  generation int
+}
+
+// WithBlockCache makes RunFrame execute through a cache of pre-decoded
+// blocks instead of fetching and decoding every instruction. Results are
+// the same as the plain interpreter; the cache is dropped whenever the ROM
+// writes to memory that holds cached code.
+func WithBlockCache() Option {
+  return func(cpu *CPU) {
+    cpu.blocks = &blockCache{}
+  }
+}
+
+func (c *blockCache) reset(size int) {
+  c.blocks = make([]*block, size)
+  c.code = make([]bool, size)
+  c.generation++
+}
+
+// wrote tells the block cache that n bytes at addr have changed.
+func (cpu *CPU) wrote(addr uint16, n int) {
+  if cpu.blocks == nil {
+    return
+  }
+  for j := 0; j < n && int(addr) + j < len(cpu.blocks.code); j++ {
+    if cpu.blocks.code[int(addr) + j] {
+      cpu.blocks.reset(len(cpu.memory))
+      return
+    }
+  }
+}
+
+// runBlocks executes cycles instructions from the block cache.
+func (cpu *CPU) runBlocks(cycles int) error {
+  cache := cpu.blocks
+  for cycles > 0 {
+    if cpu.exited || cpu.vblank {
+      return nil
+    }
+    if int(cpu.pc) + 1 >= len(cpu.memory) {
+      return cpu.fault(MemoryOutOfBounds, 0)
+    }
+    b := cache.blocks[cpu.pc]
+    if b == nil {
+      b = cpu.compileBlock(cpu.pc)
+    }
+    generation := cache.generation
+    for _, op := range b.ops {
+      if err := op(cpu); err != nil {
+        return err
+      }
+      cycles--
+      if cycles == 0 || cache.generation != generation || cpu.exited || cpu.vblank {
+        break
+      }
+    }
+  }
+  return nil
+}
+
+// compileBlock decodes instructions from pc up to and including the first
+// one that can change the flow of control.
+func (cpu *CPU) compileBlock(pc uint16) *block {
+  b := &block{start: pc}
+  addr := int(pc)
+  for len(b.ops) < maxBlockLength && addr + 1 < len(cpu.memory) {
+    ins := Decode(uint16(cpu.memory[addr]) << 8 | uint16(cpu.memory[addr + 1]))
+    b.ops = append(b.ops, cpu.compile(ins))
+    addr += ins.Size()
+    if endsBlock(ins) || ins.Kind.Platform() > cpu.platform {
+      break
+    }
+  }
+  b.end = addr
+  for j := int(pc); j < b.end && j < len(cpu.blocks.code); j++ {
+    cpu.blocks.code[j] = true
+  }
+  cpu.blocks.blocks[pc] = b
+  return b
+}
+
+func endsBlock(ins Instruction) bool {
+  switch ins.Kind {
+    case KindUnknown, KindJP, KindCALL, KindRET, KindJPV, KindSEImm, KindSNEImm,
+      KindSEReg, KindSNEReg, KindSKP, KindSKNP, KindLDK, KindEXIT:
+      return true
+  }
+  return false
+}
+
+// compile turns an instruction into an op. The instructions that dominate
+// most ROMs get a specialised closure; everything else goes through
+// execute so both engines share one implementation.
+func (cpu *CPU) compile(ins Instruction) op {
+  if ins.Kind.Platform() > cpu.platform {
+    return func(cpu *CPU) error {
+      return cpu.execute(ins)
+    }
+  }
+  x, y, nn, nnn := ins.X, ins.Y, ins.NN, ins.NNN
+  switch ins.Kind {
+    case KindJP:
+      return func(cpu *CPU) error {
+        cpu.pc = nnn
+        return nil
+      }
+    case KindLDImm:
+      return func(cpu *CPU) error {
+        cpu.v[x] = nn
+        cpu.pc += 2
+        return nil
+      }
+    case KindADDImm:
+      return func(cpu *CPU) error {
+        cpu.v[x] += nn
+        cpu.pc += 2
+        return nil
+      }
+    case KindLDReg:
+      return func(cpu *CPU) error {
+        cpu.v[x] = cpu.v[y]
+        cpu.pc += 2
+        return nil
+      }
+    case KindLDI:
+      return func(cpu *CPU) error {
+        cpu.i = nnn
+        cpu.pc += 2
+        return nil
+      }
+    case KindADDI:
+      return func(cpu *CPU) error {
+        cpu.i += uint16(cpu.v[x])
+        cpu.pc += 2
+        return nil
+      }
+    case KindSEImm:
+      return func(cpu *CPU) error {
+        if cpu.v[x] == nn {
+          cpu.skip()
+        }
+        cpu.pc += 2
+        return nil
+      }
+    case KindSNEImm:
+      return func(cpu *CPU) error {
+        if cpu.v[x] != nn {
+          cpu.skip()
+        }
+        cpu.pc += 2
+        return nil
+      }
+    case KindSEReg:
+      return func(cpu *CPU) error {
+        if cpu.v[x] == cpu.v[y] {
+          cpu.skip()
+        }
+        cpu.pc += 2
+        return nil
+      }
+    case KindSNEReg:
+      return func(cpu *CPU) error {
+        if cpu.v[x] != cpu.v[y] {
+          cpu.skip()
+        }
+        cpu.pc += 2
+        return nil
+      }
+    case KindOR, KindAND, KindXOR, KindADDReg, KindSUB, KindSHR, KindSUBN, KindSHL:
+      return func(cpu *CPU) error {
+        cpu.executeALU(ins)
+        cpu.pc += 2
+        return nil
+      }
+  }
+  return func(cpu *CPU) error {
+    return cpu.execute(ins)
+  }
+}
+
+// useBlocks reports whether RunFrame should go through the block cache.
+// Tracing and memory watches need every fetch, so they fall back to the
+// interpreter.
+func (cpu *CPU) useBlocks() bool {
+  return cpu.blocks != nil && cpu.watches == nil &&
+    !cpu.logger.Enabled(context.Background(), LevelTrace)
+}
@@ -0,0 +1,117 @@
+package cpu
+
+import (
+  "bytes"
+  "testing"
+)
+
+// selfModifying rewrites the operand of the instruction at 0x204 on every
+// pass through its loop, so a stale block cache gives a different V2.
+var selfModifying = []uint8{
+  0x6a, 0x00, /* LD VA, 0 */
+  0x7a, 0x01, /* ADD VA, 1 */
+  0x61, 0x00, /* LD V1, 0 (patched) */
+  0x82, 0x10, /* LD V2, V1 */
+  0x60, 0x61, /* LD V0, 0x61 */
+  0x81, 0xa0, /* LD V1, VA */
+  0xa2, 0x04, /* LD I, 0x204 */
+  0xf1, 0x55, /* LD [I], V1 */
+  0x12, 0x02, /* JP 0x202 */
+}
+
+func checkSameState(a *CPU, b *CPU, t *testing.T) {
+  if a.pc != b.pc || a.i != b.i || a.v != b.v || a.sp != b.sp || a.stack != b.stack {
+    t.Errorf("Registers differ. Interpreter pc=%#x i=%#x v=%v, cache pc=%#x i=%#x v=%v", a.pc, a.i, a.v, b.pc, b.i, b.v)
+  }
+  if !bytes.Equal(a.memory, b.memory) {
+    t.Errorf("Memory differs")
+  }
+  if a.planes != b.planes {
+    t.Errorf("Display differs")
+  }
+}
+
+func TestBlockCacheSelfModifying(t *testing.T) {
+  plain := NewCPU()
+  cached := NewCPU(WithBlockCache())
+  plain.LoadRom(selfModifying)
+  cached.LoadRom(selfModifying)
+
+  for frame := 0; frame < 100; frame++ {
+    if err := plain.RunFrame(7); err != nil {
+      t.Fatal(err)
+    }
+    if err := cached.RunFrame(7); err != nil {
+      t.Fatal(err)
+    }
+    checkSameState(&plain, &cached, t)
+  }
+  if cached.v[2] == 0 {
+    t.Errorf("Expected the patched instruction to have run")
+  }
+}
+
+func TestBlockCacheRom(t *testing.T) {
+  rom := []uint8{
+    0x00, 0xe0, /* CLS */
+    0x60, 0x00, /* LD V0, 0 */
+    0x61, 0x00, /* LD V1, 0 */
+    0xa2, 0x40, /* LD I, 0x240 */
+    0xf0, 0x33, /* LD B, V0 */
+    0xd0, 0x13, /* DRW V0, V1, 3 */
+    0x70, 0x05, /* ADD V0, 5 */
+    0x30, 0x3c, /* SE V0, 60 */
+    0x12, 0x08, /* JP 0x208 */
+    0x22, 0x18, /* CALL 0x218 */
+    0x12, 0x02, /* JP 0x202 */
+    0x71, 0x01, /* ADD V1, 1 */
+    0x00, 0xee, /* RET */
+  }
+  plain := NewCPU(WithQuirks(QuirksVIP))
+  cached := NewCPU(WithQuirks(QuirksVIP), WithBlockCache())
+  plain.LoadRom(rom)
+  cached.LoadRom(rom)
+
+  for frame := 0; frame < 60; frame++ {
+    plain.RunFrame(11)
+    cached.RunFrame(11)
+  }
+  checkSameState(&plain, &cached, t)
+}
+
+var benchmarkLoop = []uint8{
+  0x60, 0x00, /* LD V0, 0 */
+  0x70, 0x01, /* ADD V0, 1 */
+  0x81, 0x04, /* ADD V1, V0 */
+  0x82, 0x13, /* XOR V2, V1 */
+  0xa3, 0x00, /* LD I, 0x300 */
+  0xf0, 0x1e, /* ADD I, V0 */
+  0x40, 0xff, /* SNE V0, 0xff */
+  0x12, 0x00, /* JP 0x200 */
+  0x12, 0x02, /* JP 0x202 */
+}
+
+// BenchmarkRunFrame reports how many instructions per second each engine
+// runs headless, where frames are as long as the farm can make them.
+func BenchmarkRunFrame(b *testing.B) {
+  for _, e := range []struct {
+    name string
+    opts []Option
+  }{
+    {"interpreter", nil},
+    {"blocks", []Option{WithBlockCache()}},
+  } {
+    b.Run(e.name, func(b *testing.B) {
+      cpu := NewCPU(e.opts...)
+      cpu.LoadRom(benchmarkLoop)
+      b.ResetTimer()
+      const cycles = 100000
+      for n := 0; n < b.N; n++ {
+        if err := cpu.RunFrame(cycles); err != nil {
+          b.Fatal(err)
+        }
+      }
+      b.ReportMetric(float64(b.N)*cycles/b.Elapsed().Seconds(), "instructions/s")
+    })
+  }
+}
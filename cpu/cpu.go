@@ -1,14 +1,13 @@
 package cpu
 
 import (
-  "math/rand"
-  "time"
-  "fmt"
-  "errors"
+  "crypto/sha1"
+  "log/slog"
+  "math/rand/v2"
 )
 
 type CPU struct {
-  memory  [4096]uint8
+  memory  []uint8
   i     uint16
   pc    uint16
   v   [16]uint8
@@ -17,24 +16,60 @@ type CPU struct {
   dtimer  uint8
   stimer  uint8
   key   [16]bool
-  display [64*32]bool
+  keyWait     bool
+  keyWaitDown uint16
+  keyWaitUp   uint8
+  vblank  bool
+  planes  [2][highResWidth*highResHeight]bool
+  planeMask uint8
+  pattern [16]uint8
+  pitch   uint8
+  hires   bool
+  exited  bool
+  rpl     [16]uint8
+  platform Platform
+  quirks  Quirks
+  unknown UnknownOpcodePolicy
+  unknownHandler UnknownOpcodeHandler
+  logger  *slog.Logger
+  blocks  *blockCache
+  seed    uint64
+  seeded  bool
+  rng     rand.Source
+  romHash [sha1.Size]byte
+  watches []watch
+  watchID int
   RefreshScreen bool
 }
 
-func max(a, b uint8) uint8 {
-    if a > b {
-        return a
-    }
-    return b
+// KeyDown marks key k (0x0-0xF) as held.
+func (cpu *CPU) KeyDown(k uint8) {
+  if k > 15 {
+    return
+  }
+  cpu.key[k] = true
+  if cpu.keyWait {
+    cpu.keyWaitDown |= 1 << k
+  }
+  cpu.logger.Debug("key down", "key", k)
 }
 
-func (cpu *CPU) SetKey(k uint8) {
-  cpu.key[k] = true
-  fmt.Printf("key set %x\n",k)
+// KeyUp marks key k (0x0-0xF) as released.
+func (cpu *CPU) KeyUp(k uint8) {
+  if k > 15 {
+    return
+  }
+  cpu.key[k] = false
+  if cpu.keyWait && cpu.keyWaitDown & (1 << k) != 0 && cpu.keyWaitUp == 0xFF {
+    cpu.keyWaitUp = k
+  }
+  cpu.logger.Debug("key up", "key", k)
 }
 
+// Display is the framebuffer, Width() pixels per row and Height() rows.
+// On XO-CHIP it is the first bitplane; see Plane and Colors.
 func (cpu *CPU) Display() []bool {
-  return cpu.display[:]
+  return cpu.Plane(0)
 }
 
 func getAddress(instruction uint16) uint16 {
@@ -57,256 +92,473 @@ func getY(instruction uint16) uint8 {
   return uint8((instruction & 0x00F0) >> 4)
 }
 
-func fontAddress(font uint8) uint16 {
+func fontAddress(font uint8) (uint16, bool) {
   if font > 0xF {
-    panic(errors.New("Don't have fonts beyond 0xF"))
+    return 0, false
   }
-  return uint16(5*font)
+  return uint16(5*font), true
 }
 
-func NewCPU() CPU {
-  rand.Seed(time.Now().UTC().UnixNano())
+func NewCPU(opts ...Option) CPU {
   var cpu CPU
+  cpu.logger = discardLogger
+  WithSeed(newSeed())(&cpu)
+  for _, opt := range opts {
+    opt(&cpu)
+  }
   cpu.pc = 0x200
+  cpu.memory = make([]uint8, memorySize(cpu.platform))
+  cpu.planeMask = 1
+  if cpu.blocks != nil {
+    cpu.blocks.reset(len(cpu.memory))
+  }
   copy(cpu.memory[:], fonts[:])
+  copy(cpu.memory[bigFontStart:], bigFonts[:])
   cpu.RefreshScreen = false
   return cpu
 }
 
+// LoadRom copies a program into memory at 0x200. Anything that doesn't fit
+// is dropped.
 func (cpu *CPU) LoadRom(buff []uint8) {
-  for i, val := range buff {
-    cpu.memory[0x200 + i] = val
-  } 
+  copy(cpu.memory[0x200:], buff)
+  cpu.romHash = sha1.Sum(buff)
+  cpu.wrote(0x200, len(buff))
+}
+
+// ROMHash is the SHA-1 of the last ROM passed to LoadRom.
+func (cpu *CPU) ROMHash() [sha1.Size]byte {
+  return cpu.romHash
 }
 
-func (cpu *CPU) RunCycle() {
+// RunCycle fetches and executes a single instruction. Timers are not
+// touched; see TickTimers and RunFrame. A ROM that misbehaves makes it
+// return a *Fault and leaves the PC on the offending instruction. Once the
+// ROM has exited, or while it waits for the display, nothing more is
+// executed.
+func (cpu *CPU) RunCycle() error {
+  if cpu.exited || cpu.vblank {
+    return nil
+  }
+  if int(cpu.pc) + 1 >= len(cpu.memory) {
+    return cpu.fault(MemoryOutOfBounds, 0)
+  }
   instruction := uint16(cpu.memory[cpu.pc]) << 8 | uint16(cpu.memory[cpu.pc + 1]);
-  cpu.executeInstruction(instruction)
-  if cpu.dtimer != 0 {
-    cpu.dtimer = 0
+  cpu.accessed(AccessFetch, cpu.pc, 2)
+  return cpu.executeInstruction(instruction)
+}
+
+// TickTimers counts the delay and sound timers down by one and ends any
+// wait for the display. It should be called 60 times per second of
+// emulated time.
+func (cpu *CPU) TickTimers() {
+  cpu.vblank = false
+  if cpu.dtimer > 0 {
+    cpu.dtimer--
   }
-  if cpu.stimer == 1 {
-    // fmt.Println("BOOP")
+  if cpu.stimer > 0 {
+    cpu.stimer--
   }
-  
-  cpu.stimer = max(0, cpu.stimer - 1)
 }
 
-func (cpu *CPU) clearKeys() {
-  for i := range cpu.key {
-    cpu.key[i] = false
+// RunFrame emulates one 60 Hz frame: cyclesPerFrame instructions followed
+// by a single timer tick. It stops at the first fault.
+func (cpu *CPU) RunFrame(cyclesPerFrame int) error {
+  if cpu.useBlocks() {
+    if err := cpu.runBlocks(cyclesPerFrame); err != nil {
+      return err
+    }
+    cpu.TickTimers()
+    return nil
+  }
+  for c := 0; c < cyclesPerFrame; c++ {
+    if err := cpu.RunCycle(); err != nil {
+      return err
+    }
   }
+  cpu.TickTimers()
+  return nil
 }
 
-func (cpu *CPU) executeInstruction(instruction uint16) {
-  fmt.Printf("instruction %x\n", instruction)
-  switch 0xF000 & instruction {
-    case 0x0000:
-      switch 0x00FF & instruction {
-        case 0x00E0:
-          for i, _ := range cpu.display {
-            cpu.display[i] = false
-          }
-          cpu.RefreshScreen = true
-          cpu.pc += 2
-        case 0x00EE:
-          cpu.sp--
-          cpu.pc = cpu.stack[cpu.sp] + 2
-      }
-    case 0x1000:
-      cpu.pc = getAddress(instruction)
-    case 0x2000:
+// WaitingForDisplay reports whether a sprite drawn with the DisplayWait
+// quirk has stopped the CPU until the next TickTimers.
+func (cpu *CPU) WaitingForDisplay() bool {
+  return cpu.vblank
+}
+
+// SoundActive reports whether the buzzer should currently be sounding.
+func (cpu *CPU) SoundActive() bool {
+  return cpu.stimer > 0
+}
+
+func (cpu *CPU) executeInstruction(instruction uint16) error {
+  cpu.trace(instruction)
+  return cpu.execute(Decode(instruction))
+}
+
+func (cpu *CPU) execute(ins Instruction) error {
+  if ins.Kind == KindUnknown || ins.Kind.Platform() > cpu.platform {
+    return cpu.unknownOpcode(ins.Opcode)
+  }
+  switch ins.Kind {
+    case KindCLS:
+      cpu.clearDisplay()
+      cpu.pc += 2
+    case KindRET:
+      if cpu.sp == 0 {
+        return cpu.fault(StackUnderflow, ins.Opcode)
+      }
+      cpu.sp--
+      cpu.pc = cpu.stack[cpu.sp] + 2
+    case KindSCD:
+      cpu.scroll(0, cpu.scrollDistance(int(ins.N)))
+      cpu.pc += 2
+    case KindSCU:
+      cpu.scroll(0, -cpu.scrollDistance(int(ins.N)))
+      cpu.pc += 2
+    case KindSCR:
+      cpu.scroll(cpu.scrollDistance(4), 0)
+      cpu.pc += 2
+    case KindSCL:
+      cpu.scroll(-cpu.scrollDistance(4), 0)
+      cpu.pc += 2
+    case KindEXIT:
+      cpu.exited = true
+      cpu.pc += 2
+    case KindLOW, KindHIGH:
+      cpu.setHires(ins.Kind == KindHIGH)
+      cpu.pc += 2
+    case KindJP:
+      cpu.pc = ins.NNN
+    case KindCALL:
+      if int(cpu.sp) >= len(cpu.stack) {
+        return cpu.fault(StackOverflow, ins.Opcode)
+      }
       cpu.stack[cpu.sp] = cpu.pc
       cpu.sp++
-      cpu.pc = getAddress(instruction)
-    case 0x3000:
-      if cpu.getRegister(getX(instruction)) == get8BitConstant(instruction) {
-        cpu.pc  += 2
+      cpu.pc = ins.NNN
+    case KindSEImm:
+      if cpu.getRegister(ins.X) == ins.NN {
+        cpu.skip()
       }
       cpu.pc    += 2
-    case 0x4000:
-      fmt.Println(cpu.getRegister(getX(instruction)))
-      fmt.Println((getX(instruction)))
-      fmt.Println(get8BitConstant(instruction))
-      if cpu.getRegister(getX(instruction)) != get8BitConstant(instruction) {
-        cpu.pc  += 2
+    case KindSNEImm:
+      if cpu.getRegister(ins.X) != ins.NN {
+        cpu.skip()
       }
       cpu.pc    += 2
-    case 0x5000:
-      if cpu.getRegister(getX(instruction)) == cpu.getRegister(getY(instruction)) {
-        cpu.pc  += 2
+    case KindSEReg:
+      if cpu.getRegister(ins.X) == cpu.getRegister(ins.Y) {
+        cpu.skip()
       }
       cpu.pc    += 2
-    case 0x6000:
-      cpu.setRegister(getX(instruction), get8BitConstant(instruction))
+    case KindSAVE, KindLOAD:
+      if err := cpu.saveLoadRange(ins); err != nil {
+        return err
+      }
       cpu.pc    += 2
-    case 0x7000:
-      cpu.setRegister(getX(instruction), cpu.getRegister(getX(instruction)) + get8BitConstant(instruction))
+    case KindLDImm:
+      cpu.setRegister(ins.X, ins.NN)
       cpu.pc    += 2
-    case 0x8000:
-      vx := cpu.getRegister(getX(instruction))
-      vy := cpu.getRegister(getY(instruction))
-      fmt.Println(vx, vy)
-      switch 0x000F & instruction {
-        case 0x0000:
-          cpu.setRegister(getX(instruction), vy)
-        case 0x0001:
-          cpu.setRegister(getX(instruction), vx | vy)
-        case 0x0002:
-          cpu.setRegister(getX(instruction), vx & vy)
-        case 0x0003:
-          cpu.setRegister(getX(instruction), vx ^ vy)
-        case 0x0004:
-          cpu.setRegister(getX(instruction), vx + vy)
-          cpu.setRegister(0xF, 0)
-          if vx > 0xFF - vy {
-            cpu.setRegister(0xF, 1)
-          }
-        case 0x0005:
-          cpu.setRegister(getX(instruction), vx - vy)
-          cpu.setRegister(0xF, 1)
-          if vy > vx {
-            cpu.setRegister(0xF, 0)
-          }
-        case 0x0006:
-          cpu.setRegister(getX(instruction), vy >> 1)
-          cpu.setRegister(0xF, vy & 0x1)
-        case 0x0007:
-          cpu.setRegister(getX(instruction), vy - vx)
-          cpu.setRegister(0xF, 0)
-          if vx > vy {
-            cpu.setRegister(0xF, 1)
-          }
-        case 0x000E:
-          cpu.setRegister(getX(instruction), vy << 1)
-          cpu.setRegister(getY(instruction), vy << 1)
-          cpu.setRegister(0xF, vy & 0x80)
+    case KindADDImm:
+      cpu.setRegister(ins.X, cpu.getRegister(ins.X) + ins.NN)
+      cpu.pc    += 2
+    case KindLDReg, KindOR, KindAND, KindXOR, KindADDReg, KindSUB, KindSHR, KindSUBN, KindSHL:
+      cpu.executeALU(ins)
+      cpu.pc += 2
+    case KindSNEReg:
+      if cpu.getRegister(ins.X) != cpu.getRegister(ins.Y) {
+        cpu.skip()
+      }
+      cpu.pc    += 2
+    case KindLDI:
+      cpu.i     =  ins.NNN
+      cpu.pc    += 2
+    case KindJPV:
+      if cpu.quirks.JumpVX {
+        cpu.pc  = uint16(cpu.getRegister(ins.X)) + ins.NNN
+      } else {
+        cpu.pc  = uint16(cpu.getRegister(0)) + ins.NNN
+      }
+    case KindRND:
+      cpu.setRegister(ins.X, uint8(cpu.rng.Uint64()) & ins.NN)
+      cpu.pc    += 2
+    case KindDRW:
+      if err := cpu.drawSprite(ins); err != nil {
+        return err
+      }
+      cpu.RefreshScreen = true
+      cpu.pc += 2
+    case KindSKP:
+      if cpu.keyPressed(cpu.getRegister(ins.X)) {
+        cpu.skip()
+      }
+      cpu.pc += 2
+    case KindSKNP:
+      if !cpu.keyPressed(cpu.getRegister(ins.X)) {
+        cpu.skip()
+      }
+      cpu.pc += 2
+    case KindLDILong:
+      if int(cpu.pc) + 3 >= len(cpu.memory) {
+        return cpu.fault(MemoryOutOfBounds, ins.Opcode)
+      }
+      cpu.i      = uint16(cpu.memory[cpu.pc + 2]) << 8 | uint16(cpu.memory[cpu.pc + 3])
+      cpu.accessed(AccessFetch, cpu.pc + 2, 2)
+      cpu.pc    += 4
+    case KindPLANE:
+      cpu.planeMask = ins.X & 0x3
+      cpu.pc    += 2
+    case KindAUDIO:
+      if int(cpu.i) + len(cpu.pattern) > len(cpu.memory) {
+        return cpu.fault(MemoryOutOfBounds, ins.Opcode)
       }
+      copy(cpu.pattern[:], cpu.memory[cpu.i:])
+      cpu.accessed(AccessRead, cpu.i, len(cpu.pattern))
+      cpu.pc    += 2
+    case KindPITCH:
+      cpu.pitch  = cpu.getRegister(ins.X)
+      cpu.pc    += 2
+    case KindLDVDT:
+      cpu.setRegister(ins.X, cpu.dtimer)
       cpu.pc += 2
-    case 0x9000:
-      if cpu.getRegister(getX(instruction)) != cpu.getRegister(getY(instruction)) {
+    case KindLDK:
+      // like the VIP, wait for a key to be pressed and then released
+      if !cpu.keyWait {
+        cpu.keyWait     = true
+        cpu.keyWaitDown = 0
+        cpu.keyWaitUp   = 0xFF
+      }
+      if cpu.keyWaitUp != 0xFF {
+        cpu.logger.Debug("got key", "key", cpu.keyWaitUp)
+        cpu.setRegister(ins.X, cpu.keyWaitUp)
+        cpu.keyWait = false
         cpu.pc  += 2
       }
+      //no key released yet
+    case KindLDDT:
+      cpu.dtimer = cpu.getRegister(ins.X)
       cpu.pc    += 2
-    case 0xA000:
-      cpu.i     =  getAddress(instruction)
+    case KindLDST:
+      cpu.stimer = cpu.getRegister(ins.X)
       cpu.pc    += 2
-    case 0xB000:
-      cpu.pc    = uint16(cpu.getRegister(0)) + getAddress(instruction)
-      if cpu.pc > 0xff {
-
+    case KindADDI:
+      cpu.i     += uint16(cpu.getRegister(ins.X))
+      cpu.pc    += 2
+      // check notes on wiki, VF might be set
+    case KindLDF:
+      addr, ok  := fontAddress(cpu.getRegister(ins.X))
+      if !ok {
+        return cpu.fault(BadFont, ins.Opcode)
       }
-    case 0xC000:
-      cpu.setRegister(getX(instruction), uint8(rand.Uint32()) & get8BitConstant(instruction))
+      cpu.i      = addr
       cpu.pc    += 2
-    case 0xD000:
-      vx := uint16(cpu.getRegister(getX(instruction)))
-      vy := uint16(cpu.getRegister(getY(instruction)))
-      n  := uint16(get4BitConstant(instruction))
-      var pixel uint8
-      cpu.setRegister(0xF, 0)
-      for j := uint16(0); j < n; j++ {
-        pixel = cpu.memory[cpu.i + uint16(j)]
-        for k := uint16(0); k < 8; k++ {
-          if (pixel & (0x80 >> k)) == (0x80 >> k) { //pixel is set
-            // fmt.Printf("drawing pixel %v, row  %v\n", k, j)
-            if vx > 63 {
-              fmt.Println(vx)
-              panic(errors.New("weewoo"))
-            }
-            if cpu.display[vx + k + (vy + j)*64] {
-              cpu.setRegister(0xF, 1)
-            }
-            // fmt.Printf("display index %v\n", vx + k + (vy + j)*64)
-            cpu.display[vx + k + (vy + j)*64] = !cpu.display[vx + k + (vy + j)*64]
-          } 
-        }
+    case KindLDHF:
+      addr, ok  := bigFontAddress(cpu.getRegister(ins.X))
+      if !ok {
+        return cpu.fault(BadFont, ins.Opcode)
       }
-      cpu.RefreshScreen = true
+      cpu.i      = addr
+      cpu.pc    += 2
+    case KindLDB:
+      if int(cpu.i) + 3 > len(cpu.memory) {
+        return cpu.fault(MemoryOutOfBounds, ins.Opcode)
+      }
+      vx := cpu.getRegister(ins.X)
+      cpu.memory[cpu.i]   = vx / 100 
+      cpu.memory[cpu.i+1] = (vx / 10) % 10
+      cpu.memory[cpu.i+2] = (vx % 100) % 10
+      cpu.wrote(cpu.i, 3)
+      cpu.accessed(AccessWrite, cpu.i, 3)
       cpu.pc += 2
-    case 0xE000:
-      switch 0x00FF & instruction {
-        case 0x009E:
-          if cpu.keyPressed(cpu.getRegister(getX(instruction))) {
-            cpu.pc += 2
-            cpu.key[cpu.getRegister(getX(instruction))] = false
-          }
-          cpu.pc += 2
-        case 0x00A1:
-          if !cpu.keyPressed(cpu.getRegister(getX(instruction))) {
-            cpu.pc += 2
-          } else {
-            cpu.key[cpu.getRegister(getX(instruction))] = false
-          }
-          cpu.pc += 2
-      }
-    case 0xF000:
-      switch 0x00FF & instruction {
-        case 0x0007:
-          fmt.Println("dtimer", cpu.dtimer)
-          cpu.setRegister(getX(instruction), cpu.dtimer)
-          cpu.pc += 2
-        case 0x000A:
-          if k := cpu.getKey(); k != 0xFF {
-            fmt.Printf("got key %x\n", k)
-            fmt.Println(cpu.key)
-            cpu.clearKeys()
-            fmt.Println(cpu.key)
-            cpu.setRegister(getX(instruction), k)
-            cpu.pc  += 2
-          }
-          //no key pressed 
-        case 0x0015:
-          cpu.dtimer = cpu.getRegister(getX(instruction))
-          cpu.pc    += 2
-        case 0x0018:
-          cpu.stimer = cpu.getRegister(getX(instruction))
-          cpu.pc    += 2
-        case 0x001E:
-          cpu.i     += uint16(cpu.getRegister(getX(instruction)))
-          cpu.pc    += 2
-          // check notes on wiki, VF might be set
-        case 0x0029:
-          cpu.i      = fontAddress(cpu.getRegister(getX(instruction)))
-          cpu.pc    += 2
-        case 0x0033:
-          vx := cpu.getRegister(getX(instruction))
-          cpu.memory[cpu.i]   = vx / 100 
-          cpu.memory[cpu.i+1] = (vx / 10) % 10
-          cpu.memory[cpu.i+2] = (vx % 100) % 10
-          cpu.pc += 2
-        case 0x0055:
-          x := getX(instruction)
-          var j uint8
-          for j = 0; j <= x; j++ {
-            cpu.memory[cpu.i+uint16(j)] = cpu.getRegister(j)
-          }
-          cpu.pc    += 2
-        case 0x0065:
-          x := getX(instruction)
-          var j uint8
-          for j = 0; j <= x; j++ {
-            cpu.setRegister(j, cpu.memory[cpu.i+uint16(j)])
-          }
-          cpu.pc    += 2
+    case KindSTORE:
+      if int(cpu.i) + int(ins.X) >= len(cpu.memory) {
+        return cpu.fault(MemoryOutOfBounds, ins.Opcode)
+      }
+      var j uint8
+      for j = 0; j <= ins.X; j++ {
+        cpu.memory[cpu.i+uint16(j)] = cpu.getRegister(j)
+      }
+      cpu.wrote(cpu.i, int(ins.X) + 1)
+      cpu.accessed(AccessWrite, cpu.i, int(ins.X) + 1)
+      cpu.advanceI(ins.X)
+      cpu.pc    += 2
+    case KindREAD:
+      if int(cpu.i) + int(ins.X) >= len(cpu.memory) {
+        return cpu.fault(MemoryOutOfBounds, ins.Opcode)
       }
+      var j uint8
+      for j = 0; j <= ins.X; j++ {
+        cpu.setRegister(j, cpu.memory[cpu.i+uint16(j)])
+      }
+      cpu.accessed(AccessRead, cpu.i, int(ins.X) + 1)
+      cpu.advanceI(ins.X)
+      cpu.pc    += 2
+    case KindSTORER:
+      copy(cpu.rpl[:ins.X+1], cpu.v[:])
+      cpu.pc    += 2
+    case KindREADR:
+      copy(cpu.v[:ins.X+1], cpu.rpl[:])
+      cpu.pc    += 2
   }
+  return nil
 }
 
-func (cpu *CPU) getRegister(register uint8) uint8 {
-  if register > 15 {
-    panic(errors.New("Only have 16 registers, asking for register index ?"))
+// executeALU runs the 8XYN register arithmetic and logic instructions.
+func (cpu *CPU) executeALU(ins Instruction) {
+  vx := cpu.getRegister(ins.X)
+  vy := cpu.getRegister(ins.Y)
+  switch ins.Kind {
+    case KindLDReg:
+      cpu.setRegister(ins.X, vy)
+    case KindOR:
+      cpu.setRegister(ins.X, vx | vy)
+      if cpu.quirks.ResetVF {
+        cpu.setRegister(0xF, 0)
+      }
+    case KindAND:
+      cpu.setRegister(ins.X, vx & vy)
+      if cpu.quirks.ResetVF {
+        cpu.setRegister(0xF, 0)
+      }
+    case KindXOR:
+      cpu.setRegister(ins.X, vx ^ vy)
+      if cpu.quirks.ResetVF {
+        cpu.setRegister(0xF, 0)
+      }
+    case KindADDReg:
+      cpu.setRegister(ins.X, vx + vy)
+      cpu.setRegister(0xF, 0)
+      if vx > 0xFF - vy {
+        cpu.setRegister(0xF, 1)
+      }
+    case KindSUB:
+      cpu.setRegister(ins.X, vx - vy)
+      cpu.setRegister(0xF, 1)
+      if vy > vx {
+        cpu.setRegister(0xF, 0)
+      }
+    case KindSHR:
+      if cpu.quirks.ShiftVX {
+        vy = vx
+      }
+      cpu.setRegister(ins.X, vy >> 1)
+      cpu.setRegister(0xF, vy & 0x1)
+    case KindSUBN:
+      cpu.setRegister(ins.X, vy - vx)
+      cpu.setRegister(0xF, 1)
+      if vx > vy {
+        cpu.setRegister(0xF, 0)
+      }
+    case KindSHL:
+      if cpu.quirks.ShiftVX {
+        vy = vx
+      }
+      cpu.setRegister(ins.X, vy << 1)
+      cpu.setRegister(0xF, vy >> 7)
   }
-  return cpu.v[register]
 }
 
-func (cpu *CPU) getKey() uint8 {
-  for i, x := range cpu.key {
-    if x {
-      return uint8(i)
+// drawSprite XORs the N-row sprite at I onto the display at (VX, VY) and
+// sets VF if any lit pixel was turned off, or to a count of rows with the
+// CountCollisions quirk. On SUPER-CHIP, DXY0 draws a 16x16 sprite. On
+// XO-CHIP the sprite is drawn to each selected plane in turn, with the data
+// for the second plane following the first. The starting position wraps
+// around the screen; pixels past the right or bottom edge are clipped or
+// wrapped depending on the ClipSprites quirk.
+func (cpu *CPU) drawSprite(ins Instruction) error {
+  width, height := uint16(cpu.Width()), uint16(cpu.Height())
+  x0 := uint16(cpu.getRegister(ins.X)) % width
+  y0 := uint16(cpu.getRegister(ins.Y)) % height
+  n  := uint16(ins.N)
+  cols := uint16(8)
+  if n == 0 && cpu.platform >= PlatformSCHIP {
+    n, cols = 16, 16
+  }
+  size := n*cols/8
+  planes := 0
+  for p := range cpu.planes {
+    if cpu.planeMask & (1 << p) != 0 {
+      planes++
+    }
+  }
+  if int(cpu.i) + planes*int(size) > len(cpu.memory) {
+    return cpu.fault(MemoryOutOfBounds, ins.Opcode)
+  }
+  cpu.accessed(AccessRead, cpu.i, planes*int(size))
+  collided, clipped := 0, 0
+  addr := cpu.i
+  for p := range cpu.planes {
+    if cpu.planeMask & (1 << p) == 0 {
+      continue
+    }
+    screen := cpu.planes[p][:]
+    for j := uint16(0); j < n; j++ {
+      y := y0 + j
+      if y >= height {
+        if cpu.quirks.ClipSprites {
+          clipped += int(n - j)
+          break
+        }
+        y %= height
+      }
+      hit := false
+      var pixel uint16
+      if cols == 16 {
+        pixel = uint16(cpu.memory[addr + 2*j]) << 8 | uint16(cpu.memory[addr + 2*j + 1])
+      } else {
+        pixel = uint16(cpu.memory[addr + j]) << 8
+      }
+      for k := uint16(0); k < cols; k++ {
+        if pixel & (0x8000 >> k) == 0 {
+          continue
+        }
+        x := x0 + k
+        if x >= width {
+          if cpu.quirks.ClipSprites {
+            break
+          }
+          x %= width
+        }
+        idx := x + y*width
+        if screen[idx] {
+          hit = true
+        }
+        screen[idx] = !screen[idx]
+      }
+      if hit {
+        collided++
+      }
     }
+    addr += size
+  }
+  var vf uint8
+  if collided > 0 {
+    vf = 1
+  }
+  if cpu.hires && cpu.quirks.CountCollisions {
+    vf = uint8(collided + clipped)
+  }
+  cpu.setRegister(0xF, vf)
+  if !cpu.hires && cpu.quirks.DisplayWait {
+    cpu.vblank = true
   }
-  return 0xFF
+  return nil
+}
+
+// advanceI moves I on after FX55/FX65 stored or loaded V0 to VX, as the
+// quirks say.
+func (cpu *CPU) advanceI(x uint8) {
+  switch {
+    case cpu.quirks.IncrementI:
+      cpu.i += uint16(x) + 1
+    case cpu.quirks.IncrementIByX:
+      cpu.i += uint16(x)
+  }
+}
+
+func (cpu *CPU) getRegister(register uint8) uint8 {
+  return cpu.v[register & 0xF]
 }
 
 func (cpu *CPU) keyPressed(key uint8) bool {
@@ -317,8 +569,5 @@ func (cpu *CPU) keyPressed(key uint8) bool {
 }
 
 func (cpu *CPU) setRegister(register uint8, value uint8) {
-  if register > 15 {
-    panic(errors.New("Only have 16 registers, asking for register index ?"))
-  }
-  cpu.v[register] = value
+  cpu.v[register & 0xF] = value
 }
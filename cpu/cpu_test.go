@@ -197,28 +197,50 @@ func TestMathSub(t *testing.T) {
 
   cpu.executeInstruction(0x8015) // sub v0 v1
   checkReg(&cpu, 0, 0x43-0x21, t)
-  checkReg(&cpu, 0xf, 0, t)
+  checkReg(&cpu, 0xf, 1, t)
 
   cpu.setRegister(0, 0x12)
   cpu.setRegister(1, 0x34)
 
   cpu.executeInstruction(0x8015) // sub v0 v1
   checkReg(&cpu, 0, 222, t)
-  checkReg(&cpu, 0xf, 1, t)
+  checkReg(&cpu, 0xf, 0, t)
 
   cpu.setRegister(0, 0x34)
   cpu.setRegister(1, 0x12)
 
   cpu.executeInstruction(0x8017) // sub v0 v1
   checkReg(&cpu, 0, 222, t)
-  checkReg(&cpu, 0xf, 1, t)
+  checkReg(&cpu, 0xf, 0, t)
 
   cpu.setRegister(0, 0x12)
   cpu.setRegister(1, 0x34)
 
   cpu.executeInstruction(0x8017) // sub v0 v1
   checkReg(&cpu, 0, 0x34-0x12, t)
+  checkReg(&cpu, 0xf, 1, t)
+}
+
+func TestMathShift(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0, 0x01)
+  cpu.setRegister(1, 0x81)
+
+  cpu.executeInstruction(0x801e) // shl v0 v1
+  checkReg(&cpu, 0, 0x02, t)
+  checkReg(&cpu, 1, 0x81, t)
+  checkReg(&cpu, 0xf, 1, t)
+
+  cpu.setRegister(1, 0x40)
+
+  cpu.executeInstruction(0x801e) // shl v0 v1
+  checkReg(&cpu, 0, 0x80, t)
   checkReg(&cpu, 0xf, 0, t)
+
+  cpu.setRegister(0xf, 0x81)
+
+  cpu.executeInstruction(0x8ffe) // shl vf vf
+  checkReg(&cpu, 0xf, 1, t)
 }
 
 func TestMathBitwise(t *testing.T) {
@@ -336,4 +358,124 @@ func draw(cpu *CPU) {
     }
   }
   f.Write(out)
-}
\ No newline at end of file
+}
+func TestTimers(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0, 2)
+  cpu.executeInstruction(0xf015)
+  cpu.executeInstruction(0xf018)
+
+  cpu.RunCycle()
+  checkDtimer(&cpu, 2, t)
+  checkStimer(&cpu, 2, t)
+
+  cpu.TickTimers()
+  checkDtimer(&cpu, 1, t)
+  checkStimer(&cpu, 1, t)
+  if !cpu.SoundActive() {
+    t.Errorf("Expected sound to be active")
+  }
+
+  cpu.TickTimers()
+  cpu.TickTimers()
+  checkDtimer(&cpu, 0, t)
+  checkStimer(&cpu, 0, t)
+  if cpu.SoundActive() {
+    t.Errorf("Expected sound to be inactive")
+  }
+}
+
+func TestRunFrame(t *testing.T) {
+  cpu := NewCPU()
+  cpu.LoadRom([]uint8{
+    0x60, 0x05, /* LD V0 5 */
+    0xf0, 0x15, /* LD DT V0 */
+    0xf1, 0x07, /* LD V1 DT */
+    0x12, 0x04, /* JP 0x204 */
+  })
+
+  cpu.RunFrame(3)
+  checkReg(&cpu, 1, 5, t)
+  checkDtimer(&cpu, 4, t)
+
+  cpu.RunFrame(10)
+  checkReg(&cpu, 1, 4, t)
+  checkDtimer(&cpu, 3, t)
+}
+
+func checkPixel(cpu *CPU, x int, y int, val bool, t *testing.T) {
+  if cpu.Display()[x + y*64] != val {
+    t.Errorf("Incorrect pixel %v,%v. Got %v, wanted %v", x, y, !val, val)
+  }
+}
+
+func TestDrawWrap(t *testing.T) {
+  cpu := NewCPU()
+  cpu.i = 0 /* font 0, 0xF0 0x90 0x90 0x90 0xF0 */
+  cpu.setRegister(0, 64 + 62)
+  cpu.setRegister(1, 32 + 30)
+
+  cpu.executeInstruction(0xd015)
+  checkReg(&cpu, 0xf, 0, t)
+  checkPixel(&cpu, 62, 30, true, t)
+  checkPixel(&cpu, 1, 30, true, t)
+  checkPixel(&cpu, 62, 2, true, t)
+  checkPixel(&cpu, 1, 2, true, t)
+  checkPixel(&cpu, 2, 30, false, t)
+
+  cpu.executeInstruction(0xd015)
+  checkReg(&cpu, 0xf, 1, t)
+  for i, val := range cpu.Display() {
+    if val {
+      t.Errorf("Expected pixel %v,%v to be clear", i%64, i/64)
+    }
+  }
+}
+
+func TestDrawClip(t *testing.T) {
+  cpu := NewCPU(WithQuirks(QuirksVIP))
+  cpu.i = 0
+  cpu.setRegister(0, 64 + 62)
+  cpu.setRegister(1, 32 + 30)
+
+  cpu.executeInstruction(0xd015)
+  checkPixel(&cpu, 62, 30, true, t)
+  checkPixel(&cpu, 63, 31, false, t)
+  checkPixel(&cpu, 1, 30, false, t)
+  checkPixel(&cpu, 62, 0, false, t)
+}
+
+func TestKeys(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0, 0x5)
+
+  cpu.KeyDown(0x5)
+  cpu.executeInstruction(0xe09e)
+  checkPC(&cpu, 0x204, t)
+  cpu.executeInstruction(0xe09e) /* still held */
+  checkPC(&cpu, 0x208, t)
+
+  cpu.KeyUp(0x5)
+  cpu.executeInstruction(0xe0a1)
+  checkPC(&cpu, 0x20c, t)
+}
+
+func TestKeyWait(t *testing.T) {
+  cpu := NewCPU()
+  cpu.KeyDown(0x3) /* held before the wait doesn't count */
+
+  cpu.executeInstruction(0xf00a)
+  checkPC(&cpu, 0x200, t)
+  cpu.KeyUp(0x3)
+  cpu.executeInstruction(0xf00a)
+  checkPC(&cpu, 0x200, t)
+
+  cpu.KeyDown(0x7)
+  cpu.executeInstruction(0xf00a)
+  checkPC(&cpu, 0x200, t)
+
+  cpu.KeyUp(0x7)
+  cpu.executeInstruction(0xf00a)
+  checkPC(&cpu, 0x202, t)
+  checkReg(&cpu, 0, 0x7, t)
+}
@@ -0,0 +1,53 @@
+package cpu
+
+import (
+  "fmt"
+)
+
+// FaultKind identifies what went wrong when the CPU stopped with a Fault.
+type FaultKind int
+
+const (
+  // StackOverflow is a 2NNN call with all 16 stack slots in use.
+  StackOverflow FaultKind = iota
+  // StackUnderflow is a 00EE return with an empty stack.
+  StackUnderflow
+  // MemoryOutOfBounds is an access past the end of memory.
+  MemoryOutOfBounds
+  // InvalidOpcode is an instruction the CPU does not know how to execute.
+  InvalidOpcode
+  // BadFont is an FX29 lookup for a character that has no font sprite.
+  BadFont
+)
+
+func (k FaultKind) String() string {
+  switch k {
+    case StackOverflow:
+      return "stack overflow"
+    case StackUnderflow:
+      return "stack underflow"
+    case MemoryOutOfBounds:
+      return "memory out of bounds"
+    case InvalidOpcode:
+      return "invalid opcode"
+    case BadFont:
+      return "bad font"
+  }
+  return fmt.Sprintf("FaultKind(%d)", int(k))
+}
+
+// Fault is the error returned when a ROM makes the CPU do something it
+// can't. PC and Instruction locate the offending instruction.
+type Fault struct {
+  Kind        FaultKind
+  PC          uint16
+  Instruction uint16
+}
+
+func (f *Fault) Error() string {
+  return fmt.Sprintf("%v at pc %#04x (instruction %04x)", f.Kind, f.PC, f.Instruction)
+}
+
+func (cpu *CPU) fault(kind FaultKind, instruction uint16) error {
+  return &Fault{Kind: kind, PC: cpu.pc, Instruction: instruction}
+}
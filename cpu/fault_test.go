@@ -0,0 +1,77 @@
+package cpu
+
+import (
+  "errors"
+  "testing"
+)
+
+func checkFault(err error, kind FaultKind, pc uint16, instruction uint16, t *testing.T) {
+  var fault *Fault
+  if !errors.As(err, &fault) {
+    t.Errorf("Expected %v fault, got %v", kind, err)
+    return
+  }
+  if fault.Kind != kind || fault.PC != pc || fault.Instruction != instruction {
+    t.Errorf("Incorrect fault. Got %v, wanted %v at pc %#04x (instruction %04x)", fault, kind, pc, instruction)
+  }
+}
+
+func TestFaultStack(t *testing.T) {
+  cpu := NewCPU()
+  err := cpu.executeInstruction(0x00ee)
+  checkFault(err, StackUnderflow, 0x200, 0x00ee, t)
+
+  for i := 0; i < 16; i++ {
+    if err := cpu.executeInstruction(0x2200); err != nil {
+      t.Fatalf("Unexpected fault %v", err)
+    }
+  }
+  err = cpu.executeInstruction(0x2200)
+  checkFault(err, StackOverflow, 0x200, 0x2200, t)
+  checkSP(&cpu, 16, t)
+}
+
+func TestFaultMemory(t *testing.T) {
+  cpu := NewCPU()
+  cpu.i = 0xffe
+
+  err := cpu.executeInstruction(0xf033)
+  checkFault(err, MemoryOutOfBounds, 0x200, 0xf033, t)
+
+  err = cpu.executeInstruction(0xf255)
+  checkFault(err, MemoryOutOfBounds, 0x200, 0xf255, t)
+
+  err = cpu.executeInstruction(0xf265)
+  checkFault(err, MemoryOutOfBounds, 0x200, 0xf265, t)
+
+  err = cpu.executeInstruction(0xd015)
+  checkFault(err, MemoryOutOfBounds, 0x200, 0xd015, t)
+
+  cpu.pc = 0xfff
+  err = cpu.RunCycle()
+  checkFault(err, MemoryOutOfBounds, 0xfff, 0, t)
+}
+
+func TestFaultOpcode(t *testing.T) {
+  cpu := NewCPU()
+  for _, instruction := range []uint16{0x0123, 0x8018, 0xe0ff, 0xf0ff} {
+    err := cpu.executeInstruction(instruction)
+    checkFault(err, InvalidOpcode, 0x200, instruction, t)
+  }
+}
+
+func TestFaultFont(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0, 0x10)
+  err := cpu.executeInstruction(0xf029)
+  checkFault(err, BadFont, 0x200, 0xf029, t)
+}
+
+func TestFaultRunFrame(t *testing.T) {
+  cpu := NewCPU()
+  cpu.LoadRom([]uint8{0x00, 0xee})
+  cpu.dtimer = 1
+  err := cpu.RunFrame(10)
+  checkFault(err, StackUnderflow, 0x200, 0x00ee, t)
+  checkDtimer(&cpu, 1, t)
+}
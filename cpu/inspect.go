@@ -0,0 +1,52 @@
+package cpu
+
+// Registers is a copy of the CPU's registers, timers and keypad, for
+// debuggers and other tools.
+type Registers struct {
+  V      [16]uint8
+  I, PC  uint16
+  SP     uint8
+  Stack  [16]uint16
+  DT, ST uint8
+  Keys   [16]bool
+}
+
+// Registers returns a copy of the registers.
+func (cpu *CPU) Registers() Registers {
+  return Registers{cpu.v, cpu.i, cpu.pc, cpu.sp, cpu.stack, cpu.dtimer, cpu.stimer, cpu.key}
+}
+
+// SetRegisters overwrites the registers and timers. Keys are left alone;
+// use KeyDown and KeyUp.
+func (cpu *CPU) SetRegisters(r Registers) {
+  if int(r.SP) > len(cpu.stack) {
+    r.SP = uint8(len(cpu.stack))
+  }
+  cpu.v, cpu.i, cpu.pc, cpu.sp, cpu.stack = r.V, r.I, r.PC, r.SP, r.Stack
+  cpu.dtimer, cpu.stimer = r.DT, r.ST
+}
+
+// MemorySize is the number of bytes of memory.
+func (cpu *CPU) MemorySize() int {
+  return len(cpu.memory)
+}
+
+// Peek copies up to n bytes of memory starting at addr. It returns fewer
+// at the end of memory.
+func (cpu *CPU) Peek(addr uint16, n int) []uint8 {
+  if int(addr) >= len(cpu.memory) {
+    return nil
+  }
+  end := min(int(addr) + n, len(cpu.memory))
+  return append([]uint8(nil), cpu.memory[addr:end]...)
+}
+
+// Poke writes data to memory at addr. Whatever doesn't fit is dropped.
+// Watches are not told about it.
+func (cpu *CPU) Poke(addr uint16, data []uint8) {
+  if int(addr) >= len(cpu.memory) {
+    return
+  }
+  n := copy(cpu.memory[addr:], data)
+  cpu.wrote(addr, n)
+}
@@ -0,0 +1,44 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestRegisters(t *testing.T) {
+  cpu := NewCPU()
+  cpu.executeInstruction(0x6a12) /* LD VA, 0x12 */
+  cpu.executeInstruction(0x2300) /* CALL 0x300 */
+
+  r := cpu.Registers()
+  if r.V[0xa] != 0x12 || r.PC != 0x300 || r.SP != 1 || r.Stack[0] != 0x202 {
+    t.Errorf("Incorrect registers %+v", r)
+  }
+
+  r.V[0] = 0x34
+  r.I = 0x400
+  r.SP = 99
+  cpu.SetRegisters(r)
+  checkReg(&cpu, 0, 0x34, t)
+  checkI(&cpu, 0x400, t)
+  checkSP(&cpu, 16, t)
+}
+
+func TestPeekPoke(t *testing.T) {
+  cpu := NewCPU(WithBlockCache())
+  cpu.LoadRom([]uint8{0x60, 0x01, 0x12, 0x00})
+  cpu.RunFrame(2)
+
+  cpu.Poke(0x201, []uint8{0x07})
+  cpu.RunFrame(1)
+  checkReg(&cpu, 0, 0x07, t)
+
+  got := cpu.Peek(0x200, 2)
+  if len(got) != 2 || got[0] != 0x60 || got[1] != 0x07 {
+    t.Errorf("Incorrect peek %v", got)
+  }
+  if got := cpu.Peek(0xffe, 4); len(got) != 2 {
+    t.Errorf("Peek past the end returned %v bytes", len(got))
+  }
+  cpu.Poke(0xfff, []uint8{1, 2})
+  checkMem(&cpu, 0xfff, 1, t)
+}
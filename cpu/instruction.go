@@ -0,0 +1,327 @@
+package cpu
+
+import (
+  "fmt"
+  "strings"
+)
+
+// Kind identifies what an instruction does, independent of its operands.
+type Kind int
+
+const (
+  KindUnknown Kind = iota
+  KindCLS     // 00E0
+  KindRET     // 00EE
+  KindSCD     // 00CN
+  KindSCU     // 00DN
+  KindSCR     // 00FB
+  KindSCL     // 00FC
+  KindEXIT    // 00FD
+  KindLOW     // 00FE
+  KindHIGH    // 00FF
+  KindJP      // 1NNN
+  KindCALL    // 2NNN
+  KindSEImm   // 3XNN
+  KindSNEImm  // 4XNN
+  KindSEReg   // 5XY0
+  KindSAVE    // 5XY2
+  KindLOAD    // 5XY3
+  KindLDImm   // 6XNN
+  KindADDImm  // 7XNN
+  KindLDReg   // 8XY0
+  KindOR      // 8XY1
+  KindAND     // 8XY2
+  KindXOR     // 8XY3
+  KindADDReg  // 8XY4
+  KindSUB     // 8XY5
+  KindSHR     // 8XY6
+  KindSUBN    // 8XY7
+  KindSHL     // 8XYE
+  KindSNEReg  // 9XY0
+  KindLDI     // ANNN
+  KindJPV     // BNNN
+  KindRND     // CXNN
+  KindDRW     // DXYN
+  KindSKP     // EX9E
+  KindSKNP    // EXA1
+  KindLDILong // F000 NNNN
+  KindPLANE   // FN01
+  KindAUDIO   // F002
+  KindLDVDT   // FX07
+  KindLDK     // FX0A
+  KindLDDT    // FX15
+  KindLDST    // FX18
+  KindADDI    // FX1E
+  KindLDF     // FX29
+  KindLDHF    // FX30
+  KindLDB     // FX33
+  KindPITCH   // FX3A
+  KindSTORE   // FX55
+  KindREAD    // FX65
+  KindSTORER  // FX75
+  KindREADR   // FX85
+)
+
+type kindInfo struct {
+  mnemonic string
+  // operands is a comma separated template where Vx, Vy, x, nnn, nn and
+  // n are replaced with the instruction's fields.
+  operands string
+  platform Platform
+}
+
+var kinds = [...]kindInfo{
+  KindUnknown: {"DW", "", PlatformCHIP8},
+  KindCLS:     {"CLS", "", PlatformCHIP8},
+  KindRET:     {"RET", "", PlatformCHIP8},
+  KindSCD:     {"SCD", "n", PlatformSCHIP},
+  KindSCU:     {"SCU", "n", PlatformXOCHIP},
+  KindSCR:     {"SCR", "", PlatformSCHIP},
+  KindSCL:     {"SCL", "", PlatformSCHIP},
+  KindEXIT:    {"EXIT", "", PlatformSCHIP},
+  KindLOW:     {"LOW", "", PlatformSCHIP},
+  KindHIGH:    {"HIGH", "", PlatformSCHIP},
+  KindJP:      {"JP", "nnn", PlatformCHIP8},
+  KindCALL:    {"CALL", "nnn", PlatformCHIP8},
+  KindSEImm:   {"SE", "Vx, nn", PlatformCHIP8},
+  KindSNEImm:  {"SNE", "Vx, nn", PlatformCHIP8},
+  KindSEReg:   {"SE", "Vx, Vy", PlatformCHIP8},
+  KindSAVE:    {"SAVE", "Vx, Vy", PlatformXOCHIP},
+  KindLOAD:    {"LOAD", "Vx, Vy", PlatformXOCHIP},
+  KindLDImm:   {"LD", "Vx, nn", PlatformCHIP8},
+  KindADDImm:  {"ADD", "Vx, nn", PlatformCHIP8},
+  KindLDReg:   {"LD", "Vx, Vy", PlatformCHIP8},
+  KindOR:      {"OR", "Vx, Vy", PlatformCHIP8},
+  KindAND:     {"AND", "Vx, Vy", PlatformCHIP8},
+  KindXOR:     {"XOR", "Vx, Vy", PlatformCHIP8},
+  KindADDReg:  {"ADD", "Vx, Vy", PlatformCHIP8},
+  KindSUB:     {"SUB", "Vx, Vy", PlatformCHIP8},
+  KindSHR:     {"SHR", "Vx, Vy", PlatformCHIP8},
+  KindSUBN:    {"SUBN", "Vx, Vy", PlatformCHIP8},
+  KindSHL:     {"SHL", "Vx, Vy", PlatformCHIP8},
+  KindSNEReg:  {"SNE", "Vx, Vy", PlatformCHIP8},
+  KindLDI:     {"LD", "I, nnn", PlatformCHIP8},
+  KindJPV:     {"JP", "V0, nnn", PlatformCHIP8},
+  KindRND:     {"RND", "Vx, nn", PlatformCHIP8},
+  KindDRW:     {"DRW", "Vx, Vy, n", PlatformCHIP8},
+  KindSKP:     {"SKP", "Vx", PlatformCHIP8},
+  KindSKNP:    {"SKNP", "Vx", PlatformCHIP8},
+  KindLDILong: {"LD", "I, long", PlatformXOCHIP},
+  KindPLANE:   {"PLANE", "x", PlatformXOCHIP},
+  KindAUDIO:   {"AUDIO", "", PlatformXOCHIP},
+  KindLDVDT:   {"LD", "Vx, DT", PlatformCHIP8},
+  KindLDK:     {"LD", "Vx, K", PlatformCHIP8},
+  KindLDDT:    {"LD", "DT, Vx", PlatformCHIP8},
+  KindLDST:    {"LD", "ST, Vx", PlatformCHIP8},
+  KindADDI:    {"ADD", "I, Vx", PlatformCHIP8},
+  KindLDF:     {"LD", "F, Vx", PlatformCHIP8},
+  KindLDHF:    {"LD", "HF, Vx", PlatformSCHIP},
+  KindLDB:     {"LD", "B, Vx", PlatformCHIP8},
+  KindPITCH:   {"PITCH", "Vx", PlatformXOCHIP},
+  KindSTORE:   {"LD", "[I], Vx", PlatformCHIP8},
+  KindREAD:    {"LD", "Vx, [I]", PlatformCHIP8},
+  KindSTORER:  {"LD", "R, Vx", PlatformSCHIP},
+  KindREADR:   {"LD", "Vx, R", PlatformSCHIP},
+}
+
+// Platform is the first platform that implements the kind.
+func (k Kind) Platform() Platform {
+  return kinds[k].platform
+}
+
+// Instruction is a decoded opcode. Only the fields that the kind uses are
+// meaningful, but all of them are filled in from the opcode's nibbles.
+type Instruction struct {
+  Opcode   uint16
+  Kind     Kind
+  Mnemonic string
+  X        uint8
+  Y        uint8
+  N        uint8
+  NN       uint8
+  NNN      uint16
+}
+
+// Decode splits an opcode into its kind and operands. It knows every
+// instruction of every platform; whether the CPU executes one depends on
+// Kind.Platform.
+func Decode(opcode uint16) Instruction {
+  ins := Instruction{
+    Opcode: opcode,
+    X:      getX(opcode),
+    Y:      getY(opcode),
+    N:      get4BitConstant(opcode),
+    NN:     get8BitConstant(opcode),
+    NNN:    getAddress(opcode),
+  }
+  ins.Kind = decodeKind(opcode)
+  ins.Mnemonic = kinds[ins.Kind].mnemonic
+  return ins
+}
+
+func decodeKind(opcode uint16) Kind {
+  switch 0xF000 & opcode {
+    case 0x0000:
+      switch opcode {
+        case 0x00E0:
+          return KindCLS
+        case 0x00EE:
+          return KindRET
+        case 0x00FB:
+          return KindSCR
+        case 0x00FC:
+          return KindSCL
+        case 0x00FD:
+          return KindEXIT
+        case 0x00FE:
+          return KindLOW
+        case 0x00FF:
+          return KindHIGH
+      }
+      switch 0xFFF0 & opcode {
+        case 0x00C0:
+          return KindSCD
+        case 0x00D0:
+          return KindSCU
+      }
+    case 0x1000:
+      return KindJP
+    case 0x2000:
+      return KindCALL
+    case 0x3000:
+      return KindSEImm
+    case 0x4000:
+      return KindSNEImm
+    case 0x5000:
+      switch 0x000F & opcode {
+        case 0x0:
+          return KindSEReg
+        case 0x2:
+          return KindSAVE
+        case 0x3:
+          return KindLOAD
+      }
+    case 0x6000:
+      return KindLDImm
+    case 0x7000:
+      return KindADDImm
+    case 0x8000:
+      switch 0x000F & opcode {
+        case 0x0:
+          return KindLDReg
+        case 0x1:
+          return KindOR
+        case 0x2:
+          return KindAND
+        case 0x3:
+          return KindXOR
+        case 0x4:
+          return KindADDReg
+        case 0x5:
+          return KindSUB
+        case 0x6:
+          return KindSHR
+        case 0x7:
+          return KindSUBN
+        case 0xE:
+          return KindSHL
+      }
+    case 0x9000:
+      if 0x000F & opcode == 0 {
+        return KindSNEReg
+      }
+    case 0xA000:
+      return KindLDI
+    case 0xB000:
+      return KindJPV
+    case 0xC000:
+      return KindRND
+    case 0xD000:
+      return KindDRW
+    case 0xE000:
+      switch 0x00FF & opcode {
+        case 0x9E:
+          return KindSKP
+        case 0xA1:
+          return KindSKNP
+      }
+    case 0xF000:
+      switch opcode {
+        case 0xF000:
+          return KindLDILong
+        case 0xF002:
+          return KindAUDIO
+      }
+      switch 0x00FF & opcode {
+        case 0x01:
+          return KindPLANE
+        case 0x07:
+          return KindLDVDT
+        case 0x0A:
+          return KindLDK
+        case 0x15:
+          return KindLDDT
+        case 0x18:
+          return KindLDST
+        case 0x1E:
+          return KindADDI
+        case 0x29:
+          return KindLDF
+        case 0x30:
+          return KindLDHF
+        case 0x33:
+          return KindLDB
+        case 0x3A:
+          return KindPITCH
+        case 0x55:
+          return KindSTORE
+        case 0x65:
+          return KindREAD
+        case 0x75:
+          return KindSTORER
+        case 0x85:
+          return KindREADR
+      }
+  }
+  return KindUnknown
+}
+
+// Size is the length of the instruction in bytes. Only XO-CHIP's
+// F000 NNNN is longer than two bytes.
+func (ins Instruction) Size() int {
+  if ins.Kind == KindLDILong {
+    return 4
+  }
+  return 2
+}
+
+// String formats the instruction in the classic Cowgod assembly syntax,
+// for example "LD V3, 0x1F". The 16-bit operand of F000 NNNN lives in the
+// next word and is shown as "long".
+func (ins Instruction) String() string {
+  info := kinds[ins.Kind]
+  if ins.Kind == KindUnknown {
+    return fmt.Sprintf("%v 0x%04X", info.mnemonic, ins.Opcode)
+  }
+  if info.operands == "" {
+    return info.mnemonic
+  }
+  operands := strings.Split(info.operands, ", ")
+  for i, op := range operands {
+    switch op {
+      case "Vx":
+        operands[i] = fmt.Sprintf("V%X", ins.X)
+      case "Vy":
+        operands[i] = fmt.Sprintf("V%X", ins.Y)
+      case "x":
+        operands[i] = fmt.Sprintf("%d", ins.X)
+      case "n":
+        operands[i] = fmt.Sprintf("%d", ins.N)
+      case "nn":
+        operands[i] = fmt.Sprintf("0x%02X", ins.NN)
+      case "nnn":
+        operands[i] = fmt.Sprintf("0x%03X", ins.NNN)
+    }
+  }
+  return info.mnemonic + " " + strings.Join(operands, ", ")
+}
@@ -0,0 +1,63 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestDecode(t *testing.T) {
+  ins := Decode(0xd125)
+  if ins.Kind != KindDRW || ins.Mnemonic != "DRW" || ins.X != 1 || ins.Y != 2 || ins.N != 5 {
+    t.Errorf("Incorrect decode of d125: %+v", ins)
+  }
+
+  ins = Decode(0x2abc)
+  if ins.Kind != KindCALL || ins.NNN != 0xabc {
+    t.Errorf("Incorrect decode of 2abc: %+v", ins)
+  }
+
+  ins = Decode(0x73fe)
+  if ins.Kind != KindADDImm || ins.X != 3 || ins.NN != 0xfe {
+    t.Errorf("Incorrect decode of 73fe: %+v", ins)
+  }
+
+  for _, opcode := range []uint16{0x0123, 0x5011, 0x8018, 0x901f, 0xe0ff, 0xf0ff, 0xf100} {
+    if ins := Decode(opcode); ins.Kind != KindUnknown {
+      t.Errorf("Expected %04x to be unknown, got %v", opcode, ins)
+    }
+  }
+}
+
+func TestDecodeSize(t *testing.T) {
+  if Decode(0xf000).Size() != 4 || Decode(0xa000).Size() != 2 {
+    t.Errorf("Incorrect instruction sizes")
+  }
+}
+
+func TestInstructionString(t *testing.T) {
+  tests := map[uint16]string{
+    0x00e0: "CLS",
+    0x00c4: "SCD 4",
+    0x1234: "JP 0x234",
+    0x3a1f: "SE VA, 0x1F",
+    0x8ab4: "ADD VA, VB",
+    0xa123: "LD I, 0x123",
+    0xb300: "JP V0, 0x300",
+    0xd125: "DRW V1, V2, 5",
+    0xf265: "LD V2, [I]",
+    0xf255: "LD [I], V2",
+    0xf301: "PLANE 3",
+    0xf000: "LD I, long",
+    0x0123: "DW 0x0123",
+  }
+  for opcode, want := range tests {
+    if got := Decode(opcode).String(); got != want {
+      t.Errorf("Incorrect string for %04x. Got %q, wanted %q", opcode, got, want)
+    }
+  }
+}
+
+func TestDecodePlatform(t *testing.T) {
+  if Decode(0x00ff).Kind.Platform() != PlatformSCHIP || Decode(0x5012).Kind.Platform() != PlatformXOCHIP || Decode(0x6000).Kind.Platform() != PlatformCHIP8 {
+    t.Errorf("Incorrect instruction platforms")
+  }
+}
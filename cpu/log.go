@@ -0,0 +1,36 @@
+package cpu
+
+import (
+  "context"
+  "log/slog"
+)
+
+// LevelTrace is the level of the per-instruction trace. It sits below
+// slog.LevelDebug so that tracing only happens when a handler asks for it.
+const LevelTrace = slog.LevelDebug - 4
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// WithLogger routes the CPU's diagnostics to l. Without it the CPU is
+// silent. Instruction tracing is emitted at LevelTrace.
+func WithLogger(l *slog.Logger) Option {
+  return func(cpu *CPU) {
+    if l == nil {
+      l = discardLogger
+    }
+    cpu.logger = l
+  }
+}
+
+func (cpu *CPU) trace(instruction uint16) {
+  if !cpu.logger.Enabled(context.Background(), LevelTrace) {
+    return
+  }
+  cpu.logger.LogAttrs(context.Background(), LevelTrace, "instruction",
+    slog.Uint64("pc", uint64(cpu.pc)),
+    slog.Uint64("opcode", uint64(instruction)),
+    slog.String("ins", Decode(instruction).String()),
+    slog.Any("v", cpu.v),
+    slog.Uint64("i", uint64(cpu.i)),
+  )
+}
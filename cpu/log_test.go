@@ -0,0 +1,42 @@
+package cpu
+
+import (
+  "bytes"
+  "encoding/json"
+  "log/slog"
+  "testing"
+)
+
+func TestLogTrace(t *testing.T) {
+  var buf bytes.Buffer
+  handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace})
+  cpu := NewCPU(WithLogger(slog.New(handler)))
+  cpu.i = 0x123
+
+  cpu.executeInstruction(0x60ab)
+
+  var record struct {
+    Msg    string
+    PC     uint16 `json:"pc"`
+    Opcode uint16 `json:"opcode"`
+    V      []uint8 `json:"v"`
+    I      uint16 `json:"i"`
+  }
+  if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+    t.Fatalf("Bad trace output %q: %v", buf.String(), err)
+  }
+  if record.Msg != "instruction" || record.PC != 0x200 || record.Opcode != 0x60ab || record.I != 0x123 || len(record.V) != 16 {
+    t.Errorf("Incorrect trace record %+v", record)
+  }
+}
+
+func TestLogSilentByDefault(t *testing.T) {
+  var buf bytes.Buffer
+  handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+  cpu := NewCPU(WithLogger(slog.New(handler)))
+
+  cpu.executeInstruction(0x60ab)
+  if buf.Len() != 0 {
+    t.Errorf("Expected no trace at debug level, got %q", buf.String())
+  }
+}
@@ -0,0 +1,4 @@
+package cpu
+
+// Option configures a CPU created by NewCPU.
+type Option func(cpu *CPU)
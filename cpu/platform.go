@@ -0,0 +1,60 @@
+package cpu
+
+// Platform selects which instruction set the CPU implements. Instructions
+// from a later platform are treated as unknown opcodes on an earlier one.
+type Platform int
+
+const (
+  // PlatformCHIP8 is the base CHIP-8 instruction set.
+  PlatformCHIP8 Platform = iota
+  // PlatformSCHIP adds the SUPER-CHIP 1.1 instructions and 128x64 mode.
+  PlatformSCHIP
+  // PlatformXOCHIP adds Octo's XO-CHIP extensions: 64 KiB of memory, a
+  // second bitplane and 16-bit addressing.
+  PlatformXOCHIP
+)
+
+// DefaultPlatform is what the commands run a ROM on when neither it nor
+// the user names a platform. NewCPU on its own uses PlatformCHIP8.
+const DefaultPlatform = PlatformXOCHIP
+
+func (p Platform) String() string {
+  switch p {
+    case PlatformCHIP8:
+      return "chip8"
+    case PlatformSCHIP:
+      return "schip"
+    case PlatformXOCHIP:
+      return "xochip"
+  }
+  return "unknown"
+}
+
+func memorySize(p Platform) int {
+  if p >= PlatformXOCHIP {
+    return 0x10000
+  }
+  return 0x1000
+}
+
+// WithPlatform selects the instruction set. The default is PlatformCHIP8.
+func WithPlatform(p Platform) Option {
+  return func(cpu *CPU) {
+    cpu.platform = p
+  }
+}
+
+// Platform returns the instruction set the CPU was created with.
+func (cpu *CPU) Platform() Platform {
+  return cpu.platform
+}
+
+// ParsePlatform looks up a platform by the name String gives it.
+func ParsePlatform(name string) (Platform, bool) {
+  for p := PlatformCHIP8; p <= PlatformXOCHIP; p++ {
+    if p.String() == name {
+      return p, true
+    }
+  }
+  return 0, false
+}
@@ -0,0 +1,103 @@
+package cpu
+
+// Quirks selects how the opcodes that differ between CHIP-8 implementations
+// behave. The zero value matches the original behaviour of this interpreter.
+type Quirks struct {
+  // ShiftVX makes 8XY6/8XYE shift VX in place instead of shifting VY into VX.
+  ShiftVX bool
+  // IncrementI makes FX55/FX65 leave I pointing past the last register
+  // stored or loaded.
+  IncrementI bool
+  // IncrementIByX makes FX55/FX65 leave I pointing at the last register
+  // stored or loaded, one short of IncrementI, as CHIP-48 does. IncrementI
+  // takes precedence.
+  IncrementIByX bool
+  // JumpVX makes BNNN jump to XNN + VX instead of NNN + V0.
+  JumpVX bool
+  // ResetVF makes 8XY1/8XY2/8XY3 set VF to zero.
+  ResetVF bool
+  // ClipSprites drops sprite pixels that fall past the right or bottom
+  // edge of the screen instead of wrapping them around to the other side.
+  // The starting position of a sprite always wraps.
+  ClipSprites bool
+  // DisplayWait makes DXYN in lores wait for the next frame, as the VIP
+  // and SUPER-CHIP 1.1 wait for the vertical blank before drawing. The
+  // rest of the frame's cycles go unused.
+  DisplayWait bool
+  // HalfScroll makes 00CN, 00FB and 00FC move the lores display half as
+  // far, as SUPER-CHIP 1.1 scrolls by hires pixels in either mode. An odd
+  // 00CN distance rounds down, since half a lores pixel can't be shown.
+  HalfScroll bool
+  // CountCollisions makes DXYN in hires set VF to the number of sprite
+  // rows that collided or were clipped at the bottom edge instead of 1.
+  CountCollisions bool
+}
+
+var (
+  // QuirksVIP is the behaviour of the original COSMAC VIP interpreter.
+  QuirksVIP = Quirks{
+    IncrementI:  true,
+    ResetVF:     true,
+    ClipSprites: true,
+    DisplayWait: true,
+  }
+  // QuirksSCHIPLegacy is the behaviour of SUPER-CHIP 1.1 on the HP48.
+  QuirksSCHIPLegacy = Quirks{
+    ShiftVX:         true,
+    JumpVX:          true,
+    ClipSprites:     true,
+    DisplayWait:     true,
+    HalfScroll:      true,
+    CountCollisions: true,
+  }
+  // QuirksSCHIPModern is the behaviour modern SUPER-CHIP games expect,
+  // as Octo and most other interpreters emulate it: SUPER-CHIP 1.1
+  // without the display wait, lores scrolling or collision counting.
+  QuirksSCHIPModern = Quirks{
+    ShiftVX:     true,
+    JumpVX:      true,
+    ClipSprites: true,
+  }
+  // QuirksXOCHIP is the behaviour of Octo's XO-CHIP.
+  QuirksXOCHIP = Quirks{
+    IncrementI: true,
+  }
+)
+
+var quirkPresets = map[string]Quirks{
+  "vip":          QuirksVIP,
+  "schip-legacy": QuirksSCHIPLegacy,
+  "schip-modern": QuirksSCHIPModern,
+  "xochip":       QuirksXOCHIP,
+}
+
+// QuirksPreset looks up a named quirks preset: vip, schip-legacy,
+// schip-modern or xochip.
+func QuirksPreset(name string) (Quirks, bool) {
+  q, ok := quirkPresets[name]
+  return q, ok
+}
+
+// QuirksFor is the preset ROMs written for platform p expect: the VIP's
+// for CHIP-8, modern SUPER-CHIP's and XO-CHIP's.
+func QuirksFor(p Platform) Quirks {
+  switch p {
+    case PlatformSCHIP:
+      return QuirksSCHIPModern
+    case PlatformXOCHIP:
+      return QuirksXOCHIP
+  }
+  return QuirksVIP
+}
+
+// WithQuirks selects the quirks the CPU uses for ambiguous opcodes.
+func WithQuirks(q Quirks) Option {
+  return func(cpu *CPU) {
+    cpu.quirks = q
+  }
+}
+
+// Quirks returns the quirks the CPU was created with.
+func (cpu *CPU) Quirks() Quirks {
+  return cpu.quirks
+}
@@ -0,0 +1,155 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestQuirksShift(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0, 0x10)
+  cpu.setRegister(1, 0x03)
+
+  cpu.executeInstruction(0x8016) /* SHR V0 V1 */
+  checkReg(&cpu, 0, 0x01, t)
+  checkReg(&cpu, 0xf, 1, t)
+
+  cpu = NewCPU(WithQuirks(QuirksSCHIPModern))
+  cpu.setRegister(0, 0x10)
+  cpu.setRegister(1, 0x03)
+
+  cpu.executeInstruction(0x8016) /* SHR V0 */
+  checkReg(&cpu, 0, 0x08, t)
+  checkReg(&cpu, 0xf, 0, t)
+
+  cpu.setRegister(0, 0x81)
+  cpu.setRegister(1, 0x03)
+
+  cpu.executeInstruction(0x801e) /* SHL V0 */
+  checkReg(&cpu, 0, 0x02, t)
+  checkReg(&cpu, 1, 0x03, t)
+  checkReg(&cpu, 0xf, 1, t)
+}
+
+func TestQuirksResetVF(t *testing.T) {
+  cpu := NewCPU()
+  cpu.setRegister(0xf, 1)
+
+  cpu.executeInstruction(0x8011)
+  checkReg(&cpu, 0xf, 1, t)
+
+  cpu = NewCPU(WithQuirks(QuirksVIP))
+  for _, instruction := range []uint16{0x8011, 0x8012, 0x8013} {
+    cpu.setRegister(0xf, 1)
+    cpu.executeInstruction(instruction)
+    checkReg(&cpu, 0xf, 0, t)
+  }
+}
+
+func TestQuirksJump(t *testing.T) {
+  cpu := NewCPU(WithQuirks(QuirksSCHIPLegacy))
+  cpu.setRegister(0, 0x01)
+  cpu.setRegister(3, 0x10)
+
+  cpu.executeInstruction(0xb300)
+  checkPC(&cpu, 0x310, t)
+}
+
+func TestQuirksIncrementI(t *testing.T) {
+  cpu := NewCPU()
+  cpu.i = 0x300
+
+  cpu.executeInstruction(0xf355)
+  checkI(&cpu, 0x300, t)
+
+  cpu = NewCPU(WithQuirks(QuirksXOCHIP))
+  cpu.i = 0x300
+
+  cpu.executeInstruction(0xf355)
+  checkI(&cpu, 0x304, t)
+
+  cpu.executeInstruction(0xf165)
+  checkI(&cpu, 0x306, t)
+
+  cpu = NewCPU(WithQuirks(Quirks{IncrementIByX: true}))
+  cpu.i = 0x300
+
+  cpu.executeInstruction(0xf355)
+  checkI(&cpu, 0x303, t)
+}
+
+func TestQuirksClip(t *testing.T) {
+  cpu := NewCPU(WithQuirks(QuirksVIP))
+  cpu.i = 0 /* font 0, 0xF0 on the first row */
+  cpu.setRegister(0, 60)
+  cpu.setRegister(1, 31)
+
+  cpu.executeInstruction(0xd015)
+  for x := 60; x < 64; x++ {
+    if !cpu.Display()[31*64 + x] {
+      t.Errorf("Expected pixel %v,31 to be set", x)
+    }
+  }
+  for i, val := range cpu.Display()[:31*64] {
+    if val {
+      t.Errorf("Expected clipped pixel %v,%v to be clear", i%64, i/64)
+    }
+  }
+}
+
+func TestQuirksPreset(t *testing.T) {
+  for _, name := range []string{"vip", "schip-legacy", "schip-modern", "xochip"} {
+    if _, ok := QuirksPreset(name); !ok {
+      t.Errorf("Missing quirks preset %v", name)
+    }
+  }
+  if _, ok := QuirksPreset("chip-48"); ok {
+    t.Errorf("Unexpected quirks preset chip-48")
+  }
+  if QuirksFor(PlatformCHIP8) != QuirksVIP || QuirksFor(PlatformXOCHIP) != QuirksXOCHIP {
+    t.Errorf("Incorrect quirks for the platforms")
+  }
+}
+
+func TestQuirksSCHIPPresets(t *testing.T) {
+  if QuirksSCHIPLegacy == QuirksSCHIPModern {
+    t.Fatal("Expected the SUPER-CHIP presets to differ")
+  }
+  legacy := NewCPU(WithPlatform(PlatformSCHIP), WithQuirks(QuirksSCHIPLegacy))
+  modern := NewCPU(WithPlatform(PlatformSCHIP), WithQuirks(QuirksSCHIPModern))
+  for _, cpu := range []*CPU{&legacy, &modern} {
+    cpu.LoadRom([]uint8{0xd0, 0x15, 0x00, 0xfb})
+    cpu.RunCycle()
+    cpu.RunCycle()
+  }
+
+  // legacy waits for the display after drawing in lores
+  checkPC(&legacy, 0x202, t)
+  checkPC(&modern, 0x204, t)
+  legacy.TickTimers()
+  legacy.RunCycle()
+  checkPC(&legacy, 0x204, t)
+
+  // and scrolls lores by half as far
+  if !legacy.Display()[2] || legacy.Display()[1] {
+    t.Errorf("Expected legacy 00FB to scroll right 2 pixels")
+  }
+  if !modern.Display()[4] || modern.Display()[3] {
+    t.Errorf("Expected modern 00FB to scroll right 4 pixels")
+  }
+
+  // in hires legacy counts colliding and clipped rows in VF
+  for _, cpu := range []*CPU{&legacy, &modern} {
+    cpu.setHires(true)
+    cpu.i = 0 /* font 0, five lit rows */
+    cpu.executeInstruction(0xd015)
+    cpu.executeInstruction(0xd015)
+  }
+  checkReg(&legacy, 0xf, 5, t)
+  checkReg(&modern, 0xf, 1, t)
+  for _, cpu := range []*CPU{&legacy, &modern} {
+    cpu.setRegister(1, 62)
+    cpu.executeInstruction(0xd015)
+  }
+  checkReg(&legacy, 0xf, 3, t)
+  checkReg(&modern, 0xf, 0, t)
+}
@@ -0,0 +1,44 @@
+package cpu
+
+import (
+  "math/rand/v2"
+  "time"
+)
+
+// WithSeed seeds the CPU's random number generator, which CXNN draws from.
+// Two CPUs with the same seed produce the same random numbers. Without a
+// seed the CPU picks one from the clock; Seed reports it.
+func WithSeed(seed uint64) Option {
+  return func(cpu *CPU) {
+    cpu.seed = seed
+    cpu.seeded = true
+    cpu.rng = rand.NewPCG(seed, seed)
+  }
+}
+
+// WithRandSource replaces the CPU's random number generator with src, in
+// which case Seed reports zero. Save states can only capture its position
+// if src implements encoding.BinaryMarshaler, as the default generator
+// does.
+func WithRandSource(src rand.Source) Option {
+  return func(cpu *CPU) {
+    cpu.seed = 0
+    cpu.seeded = false
+    cpu.rng = src
+  }
+}
+
+// Seed is the seed the random number generator was created with.
+func (cpu *CPU) Seed() uint64 {
+  return cpu.seed
+}
+
+// Seeded reports whether the random numbers come from Seed, which they
+// don't after WithRandSource.
+func (cpu *CPU) Seeded() bool {
+  return cpu.seeded
+}
+
+func newSeed() uint64 {
+  return uint64(time.Now().UTC().UnixNano())
+}
@@ -0,0 +1,59 @@
+package cpu
+
+import (
+  "math/rand/v2"
+  "testing"
+)
+
+func randomBytes(cpu *CPU, n int) []uint8 {
+  out := make([]uint8, n)
+  for i := range out {
+    cpu.executeInstruction(0xc0ff)
+    out[i] = cpu.v[0]
+  }
+  return out
+}
+
+func TestRngSeed(t *testing.T) {
+  a := NewCPU(WithSeed(42))
+  b := NewCPU(WithSeed(42))
+  if a.Seed() != 42 || !a.Seeded() {
+    t.Errorf("Incorrect seed %v", a.Seed())
+  }
+  ra, rb := randomBytes(&a, 32), randomBytes(&b, 32)
+  if string(ra) != string(rb) {
+    t.Errorf("Same seed gave different numbers: %v, %v", ra, rb)
+  }
+
+  c := NewCPU(WithSeed(43))
+  if string(randomBytes(&c, 32)) == string(ra) {
+    t.Errorf("Different seeds gave the same numbers")
+  }
+}
+
+func TestRngIndependent(t *testing.T) {
+  a := NewCPU(WithSeed(7))
+  want := randomBytes(&a, 16)
+
+  a = NewCPU(WithSeed(7))
+  b := NewCPU(WithSeed(7))
+  var got []uint8
+  for i := 0; i < 16; i++ {
+    randomBytes(&b, 1)
+    got = append(got, randomBytes(&a, 1)...)
+  }
+  if string(got) != string(want) {
+    t.Errorf("CPUs share random state: %v, %v", got, want)
+  }
+}
+
+func TestRngSource(t *testing.T) {
+  cpu := NewCPU(WithRandSource(rand.NewChaCha8([32]byte{})))
+  if cpu.Seeded() {
+    t.Errorf("Expected a CPU with its own source not to be seeded")
+  }
+  cpu.executeInstruction(0xc00f)
+  if cpu.v[0] > 0xf {
+    t.Errorf("CXNN ignored its mask: %v", cpu.v[0])
+  }
+}
@@ -0,0 +1,91 @@
+package cpu
+
+const (
+  lowResWidth   = 64
+  lowResHeight  = 32
+  highResWidth  = 128
+  highResHeight = 64
+)
+
+// Width is the current horizontal resolution of the display.
+func (cpu *CPU) Width() int {
+  if cpu.hires {
+    return highResWidth
+  }
+  return lowResWidth
+}
+
+// Height is the current vertical resolution of the display.
+func (cpu *CPU) Height() int {
+  if cpu.hires {
+    return highResHeight
+  }
+  return lowResHeight
+}
+
+// Exited reports whether the ROM has stopped the CPU with 00FD.
+func (cpu *CPU) Exited() bool {
+  return cpu.exited
+}
+
+// clearDisplay blanks the selected planes.
+func (cpu *CPU) clearDisplay() {
+  for p := range cpu.planes {
+    if cpu.planeMask & (1 << p) == 0 {
+      continue
+    }
+    for i := range cpu.planes[p] {
+      cpu.planes[p][i] = false
+    }
+  }
+  cpu.RefreshScreen = true
+}
+
+// setHires switches resolution, which blanks every plane.
+func (cpu *CPU) setHires(hires bool) {
+  cpu.hires = hires
+  for p := range cpu.planes {
+    cpu.planes[p] = [highResWidth*highResHeight]bool{}
+  }
+  cpu.RefreshScreen = true
+}
+
+// scroll moves the selected planes dx pixels right and dy pixels down,
+// filling the uncovered area with blank pixels.
+func (cpu *CPU) scroll(dx, dy int) {
+  width, height := cpu.Width(), cpu.Height()
+  for p := range cpu.planes {
+    if cpu.planeMask & (1 << p) == 0 {
+      continue
+    }
+    screen := cpu.planes[p][:width*height]
+    var out [highResWidth*highResHeight]bool
+    for y := 0; y < height; y++ {
+      for x := 0; x < width; x++ {
+        sx, sy := x - dx, y - dy
+        if sx < 0 || sx >= width || sy < 0 || sy >= height {
+          continue
+        }
+        out[x + y*width] = screen[sx + sy*width]
+      }
+    }
+    copy(screen, out[:])
+  }
+  cpu.RefreshScreen = true
+}
+
+// scrollDistance is how many pixels of the current display a scroll of n
+// hires pixels covers.
+func (cpu *CPU) scrollDistance(n int) int {
+  if !cpu.hires && cpu.quirks.HalfScroll {
+    return n/2
+  }
+  return n
+}
+
+func bigFontAddress(font uint8) (uint16, bool) {
+  if font > 0xF {
+    return 0, false
+  }
+  return bigFontStart + uint16(10*font), true
+}
@@ -0,0 +1,124 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestSchipDisabled(t *testing.T) {
+  cpu := NewCPU()
+  for _, instruction := range []uint16{0x00c1, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff, 0xf030, 0xf075, 0xf085} {
+    err := cpu.executeInstruction(instruction)
+    checkFault(err, InvalidOpcode, 0x200, instruction, t)
+  }
+}
+
+func TestSchipResolution(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  if cpu.Width() != 64 || cpu.Height() != 32 || len(cpu.Display()) != 64*32 {
+    t.Errorf("Incorrect low-res size %vx%v", cpu.Width(), cpu.Height())
+  }
+
+  cpu.executeInstruction(0x00ff)
+  if cpu.Width() != 128 || cpu.Height() != 64 || len(cpu.Display()) != 128*64 {
+    t.Errorf("Incorrect high-res size %vx%v", cpu.Width(), cpu.Height())
+  }
+
+  cpu.setRegister(0, 120)
+  cpu.setRegister(1, 60)
+  cpu.i = 0
+  cpu.executeInstruction(0xd011)
+  if !cpu.Display()[120 + 60*128] {
+    t.Errorf("Expected pixel 120,60 to be set")
+  }
+
+  cpu.executeInstruction(0x00fe)
+  if cpu.Width() != 64 || cpu.Height() != 32 {
+    t.Errorf("Incorrect low-res size %vx%v", cpu.Width(), cpu.Height())
+  }
+  for i, val := range cpu.Display() {
+    if val {
+      t.Errorf("Expected pixel %v to be cleared by resolution change", i)
+    }
+  }
+}
+
+func TestSchipLargeSprite(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.executeInstruction(0x00ff)
+  cpu.i = 0x300
+  for j := 0; j < 32; j++ {
+    cpu.memory[0x300 + j] = 0xff
+  }
+  cpu.setRegister(0, 8)
+  cpu.setRegister(1, 4)
+
+  cpu.executeInstruction(0xd010)
+  set := 0
+  for _, val := range cpu.Display() {
+    if val {
+      set++
+    }
+  }
+  if set != 256 {
+    t.Errorf("Incorrect 16x16 sprite. Got %v pixels, wanted 256", set)
+  }
+  if !cpu.Display()[8 + 4*128] || !cpu.Display()[23 + 19*128] {
+    t.Errorf("16x16 sprite drawn in the wrong place")
+  }
+}
+
+func TestSchipScroll(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.executeInstruction(0x00ff)
+  cpu.planes[0][10 + 10*128] = true
+
+  cpu.executeInstruction(0x00c3)
+  if !cpu.Display()[10 + 13*128] {
+    t.Errorf("Expected 00C3 to scroll down 3 rows")
+  }
+
+  cpu.executeInstruction(0x00fb)
+  if !cpu.Display()[14 + 13*128] {
+    t.Errorf("Expected 00FB to scroll right 4 pixels")
+  }
+
+  cpu.executeInstruction(0x00fc)
+  cpu.executeInstruction(0x00fc)
+  if !cpu.Display()[6 + 13*128] {
+    t.Errorf("Expected 00FC to scroll left 4 pixels")
+  }
+}
+
+func TestSchipExit(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.LoadRom([]uint8{0x00, 0xfd, 0x60, 0x01})
+  cpu.RunFrame(10)
+  if !cpu.Exited() {
+    t.Errorf("Expected cpu to have exited")
+  }
+  checkPC(&cpu, 0x202, t)
+  checkReg(&cpu, 0, 0, t)
+}
+
+func TestSchipBigFont(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.setRegister(0, 2)
+  cpu.executeInstruction(0xf030)
+  checkI(&cpu, bigFontStart + 20, t)
+  checkMem(&cpu, cpu.i, 0x3e, t)
+}
+
+func TestSchipFlags(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.setRegister(0, 0xde)
+  cpu.setRegister(1, 0xad)
+  cpu.setRegister(2, 0xbe)
+  cpu.executeInstruction(0xf175)
+
+  cpu.setRegister(0, 0)
+  cpu.setRegister(1, 0)
+  cpu.executeInstruction(0xf285)
+  checkReg(&cpu, 0, 0xde, t)
+  checkReg(&cpu, 1, 0xad, t)
+  checkReg(&cpu, 2, 0, t)
+}
@@ -0,0 +1,277 @@
+package cpu
+
+import (
+  "bufio"
+  "bytes"
+  "encoding"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "math/rand/v2"
+)
+
+// A save state is
+//
+//   magic   "CRY8SAVE"
+//   version uint16, the major format version
+//   rom     [20]byte, SHA-1 of the ROM that was loaded
+//
+// followed by sections of a 4-byte tag, a uint32 payload length and the
+// payload, ending with an "END " section. All numbers are big-endian.
+// Readers skip sections they don't know, so new sections can be added
+// without bumping the version.
+const (
+  stateMagic   = "CRY8SAVE"
+  stateVersion = 1
+)
+
+// stateRegs, stateKeys and stateMisc are section payloads. Only ever add
+// fields at the end.
+type stateRegs struct {
+  Platform uint8
+  PC, I    uint16
+  SP       uint8
+  V        [16]uint8
+  Stack    [16]uint16
+  DT, ST   uint8
+}
+
+type stateKeys struct {
+  Held     [16]bool
+  Wait     bool
+  WaitDown uint16
+  WaitUp   uint8
+}
+
+type stateMisc struct {
+  Exited  bool
+  RPL     [16]uint8
+  Pattern [16]uint8
+  Pitch   uint8
+  VBlank  bool
+}
+
+var (
+  // ErrNotState is returned by LoadState for data that isn't a save state.
+  ErrNotState = errors.New("cpu: not a save state")
+  // ErrStateVersion is returned by LoadState for a newer format version.
+  ErrStateVersion = errors.New("cpu: unsupported save state version")
+  // ErrStateROM is returned by LoadState for a state saved with another ROM.
+  ErrStateROM = errors.New("cpu: save state is for a different ROM")
+)
+
+// SaveState writes the complete machine state to w.
+func (cpu *CPU) SaveState(w io.Writer) error {
+  bw := bufio.NewWriter(w)
+  bw.WriteString(stateMagic)
+  binary.Write(bw, binary.BigEndian, uint16(stateVersion))
+  bw.Write(cpu.romHash[:])
+
+  var regs bytes.Buffer
+  binary.Write(&regs, binary.BigEndian, stateRegs{uint8(cpu.platform), cpu.pc, cpu.i, cpu.sp, cpu.v, cpu.stack, cpu.dtimer, cpu.stimer})
+  writeSection(bw, "REGS", regs.Bytes())
+
+  writeSection(bw, "MEM ", cpu.memory)
+
+  var disp bytes.Buffer
+  disp.WriteByte(boolByte(cpu.hires))
+  disp.WriteByte(cpu.planeMask)
+  for p := range cpu.planes {
+    disp.Write(packBits(cpu.planes[p][:]))
+  }
+  writeSection(bw, "DISP", disp.Bytes())
+
+  var keys bytes.Buffer
+  binary.Write(&keys, binary.BigEndian, stateKeys{cpu.key, cpu.keyWait, cpu.keyWaitDown, cpu.keyWaitUp})
+  writeSection(bw, "KEYS", keys.Bytes())
+
+  var misc bytes.Buffer
+  binary.Write(&misc, binary.BigEndian, stateMisc{cpu.exited, cpu.rpl, cpu.pattern, cpu.pitch, cpu.vblank})
+  writeSection(bw, "MISC", misc.Bytes())
+
+  var rng bytes.Buffer
+  binary.Write(&rng, binary.BigEndian, cpu.seed)
+  if m, ok := cpu.rng.(encoding.BinaryMarshaler); ok {
+    b, err := m.MarshalBinary()
+    if err != nil {
+      return err
+    }
+    rng.Write(b)
+  }
+  writeSection(bw, "RNG ", rng.Bytes())
+
+  writeSection(bw, "END ", nil)
+  return bw.Flush()
+}
+
+// LoadState restores a state written by SaveState. The CPU must have been
+// created for the same platform and have the same ROM loaded. On error the
+// CPU is left unchanged.
+func (cpu *CPU) LoadState(r io.Reader) error {
+  br := bufio.NewReader(r)
+  var header struct {
+    Magic   [8]byte
+    Version uint16
+    ROM     [20]byte
+  }
+  if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+    return ErrNotState
+  }
+  if string(header.Magic[:]) != stateMagic {
+    return ErrNotState
+  }
+  if header.Version > stateVersion {
+    return ErrStateVersion
+  }
+  if header.ROM != cpu.romHash {
+    return ErrStateROM
+  }
+
+  next := *cpu
+  next.memory = append([]uint8(nil), cpu.memory...)
+  var rng []byte
+  for {
+    var section struct {
+      Tag    [4]byte
+      Length uint32
+    }
+    if err := binary.Read(br, binary.BigEndian, &section); err != nil {
+      return fmt.Errorf("cpu: reading save state: %w", err)
+    }
+    tag := string(section.Tag[:])
+    if tag == "END " {
+      break
+    }
+    payload := make([]byte, section.Length)
+    if _, err := io.ReadFull(br, payload); err != nil {
+      return fmt.Errorf("cpu: reading save state section %q: %w", tag, err)
+    }
+    if tag == "RNG " {
+      rng = payload
+      continue
+    }
+    if err := next.loadSection(tag, payload); err != nil {
+      return err
+    }
+  }
+
+  if rng != nil {
+    if err := next.loadRNG(rng); err != nil {
+      return err
+    }
+  }
+  if next.blocks != nil {
+    next.blocks.reset(len(next.memory))
+  }
+  next.RefreshScreen = true
+  *cpu = next
+  return nil
+}
+
+func (cpu *CPU) loadSection(tag string, payload []byte) error {
+  r := bytes.NewReader(payload)
+  switch tag {
+    case "REGS":
+      var regs stateRegs
+      if err := binary.Read(r, binary.BigEndian, &regs); err != nil {
+        return fmt.Errorf("cpu: bad save state registers: %w", err)
+      }
+      if Platform(regs.Platform) != cpu.platform {
+        return fmt.Errorf("cpu: save state is for platform %v, not %v", Platform(regs.Platform), cpu.platform)
+      }
+      if int(regs.SP) > len(cpu.stack) {
+        return fmt.Errorf("cpu: bad save state stack pointer %v", regs.SP)
+      }
+      cpu.pc, cpu.i, cpu.sp, cpu.v, cpu.stack = regs.PC, regs.I, regs.SP, regs.V, regs.Stack
+      cpu.dtimer, cpu.stimer = regs.DT, regs.ST
+    case "MEM ":
+      if len(payload) != len(cpu.memory) {
+        return fmt.Errorf("cpu: save state has %d bytes of memory, not %d", len(payload), len(cpu.memory))
+      }
+      copy(cpu.memory, payload)
+    case "DISP":
+      size := len(cpu.planes[0])
+      if len(payload) < 2 + 2*size/8 {
+        return fmt.Errorf("cpu: bad save state display")
+      }
+      cpu.hires, cpu.planeMask = payload[0] != 0, payload[1]
+      for p := range cpu.planes {
+        unpackBits(cpu.planes[p][:], payload[2 + p*size/8:])
+      }
+    case "KEYS":
+      var keys stateKeys
+      if err := binary.Read(r, binary.BigEndian, &keys); err != nil {
+        return fmt.Errorf("cpu: bad save state keys: %w", err)
+      }
+      cpu.key, cpu.keyWait, cpu.keyWaitDown, cpu.keyWaitUp = keys.Held, keys.Wait, keys.WaitDown, keys.WaitUp
+    case "MISC":
+      var misc stateMisc
+      // fields added since a state was saved are left zero
+      if n := binary.Size(misc); len(payload) < n {
+        r = bytes.NewReader(append(payload, make([]byte, n - len(payload))...))
+      }
+      if err := binary.Read(r, binary.BigEndian, &misc); err != nil {
+        return fmt.Errorf("cpu: bad save state: %w", err)
+      }
+      cpu.exited, cpu.rpl, cpu.pattern, cpu.pitch = misc.Exited, misc.RPL, misc.Pattern, misc.Pitch
+      cpu.vblank = misc.VBlank
+  }
+  return nil
+}
+
+// loadRNG restores the RNG section. The generator is shared with the CPU
+// being restored, so it is only loaded once every other section has. The
+// default generator is replaced by a new one, which is unmarshalled before
+// it is used. A source given with WithRandSource can only be restored in
+// place, so it is changed only if the rest of the section is valid.
+func (cpu *CPU) loadRNG(payload []byte) error {
+  if len(payload) < 8 {
+    return fmt.Errorf("cpu: bad save state random seed")
+  }
+  seed, state := binary.BigEndian.Uint64(payload), payload[8:]
+  if len(state) > 0 {
+    if _, ok := cpu.rng.(*rand.PCG); ok {
+      pcg := rand.NewPCG(0, 0)
+      if err := pcg.UnmarshalBinary(state); err != nil {
+        return fmt.Errorf("cpu: bad save state random state: %w", err)
+      }
+      cpu.rng = pcg
+    } else if u, ok := cpu.rng.(encoding.BinaryUnmarshaler); ok {
+      if err := u.UnmarshalBinary(state); err != nil {
+        return fmt.Errorf("cpu: bad save state random state: %w", err)
+      }
+    }
+  }
+  cpu.seed = seed
+  return nil
+}
+
+func writeSection(w io.Writer, tag string, payload []byte) {
+  w.Write([]byte(tag))
+  binary.Write(w, binary.BigEndian, uint32(len(payload)))
+  w.Write(payload)
+}
+
+func boolByte(b bool) uint8 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+func packBits(pixels []bool) []byte {
+  out := make([]byte, (len(pixels) + 7)/8)
+  for i, p := range pixels {
+    if p {
+      out[i/8] |= 0x80 >> (i % 8)
+    }
+  }
+  return out
+}
+
+func unpackBits(pixels []bool, packed []byte) {
+  for i := range pixels {
+    pixels[i] = packed[i/8] & (0x80 >> (i % 8)) != 0
+  }
+}
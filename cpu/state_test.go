@@ -0,0 +1,119 @@
+package cpu
+
+import (
+  "bytes"
+  "errors"
+  "math/rand/v2"
+  "testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformXOCHIP), WithSeed(1))
+  cpu.LoadRom(selfModifying)
+  cpu.RunFrame(50)
+  cpu.KeyDown(0x4)
+  cpu.executeInstruction(0x00ff)
+  cpu.planes[1][77] = true
+  cpu.dtimer, cpu.stimer = 9, 3
+  cpu.vblank = true
+
+  var buf bytes.Buffer
+  if err := cpu.SaveState(&buf); err != nil {
+    t.Fatal(err)
+  }
+  saved := buf.Bytes()
+  want := randomBytes(&cpu, 8)
+  cpu.RunFrame(50)
+
+  restored := NewCPU(WithPlatform(PlatformXOCHIP))
+  restored.LoadRom(selfModifying)
+  if err := restored.LoadState(bytes.NewReader(saved)); err != nil {
+    t.Fatal(err)
+  }
+  if restored.Seed() != 1 || restored.dtimer != 9 || restored.stimer != 3 || !restored.key[4] || !restored.hires || !restored.planes[1][77] || !restored.vblank {
+    t.Errorf("State not restored")
+  }
+  if got := randomBytes(&restored, 8); string(got) != string(want) {
+    t.Errorf("Random state not restored. Got %v, wanted %v", got, want)
+  }
+
+  again := NewCPU(WithPlatform(PlatformXOCHIP))
+  again.LoadRom(selfModifying)
+  again.LoadState(bytes.NewReader(saved))
+  randomBytes(&again, 8)
+  checkSameState(&cpu, &again, t)
+}
+
+func TestStateErrors(t *testing.T) {
+  cpu := NewCPU()
+  cpu.LoadRom([]uint8{0x12, 0x00})
+  var buf bytes.Buffer
+  cpu.SaveState(&buf)
+
+  if err := cpu.LoadState(bytes.NewReader([]byte("not a state"))); !errors.Is(err, ErrNotState) {
+    t.Errorf("Expected ErrNotState, got %v", err)
+  }
+
+  other := NewCPU()
+  other.LoadRom([]uint8{0x12, 0x02})
+  if err := other.LoadState(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrStateROM) {
+    t.Errorf("Expected ErrStateROM, got %v", err)
+  }
+
+  schip := NewCPU(WithPlatform(PlatformSCHIP))
+  schip.LoadRom([]uint8{0x12, 0x00})
+  schip.pc = 0x300
+  if err := schip.LoadState(bytes.NewReader(buf.Bytes())); err == nil {
+    t.Errorf("Expected platform mismatch to fail")
+  }
+  checkPC(&schip, 0x300, t)
+
+  newer := append([]byte(nil), buf.Bytes()...)
+  newer[len(stateMagic)] = 0xff
+  if err := cpu.LoadState(bytes.NewReader(newer)); !errors.Is(err, ErrStateVersion) {
+    t.Errorf("Expected ErrStateVersion, got %v", err)
+  }
+}
+
+func TestStateRandSource(t *testing.T) {
+  saved := NewCPU(WithRandSource(rand.NewChaCha8([32]byte{1})))
+  saved.LoadRom([]uint8{0x12, 0x00})
+  randomBytes(&saved, 8)
+  var buf bytes.Buffer
+  if err := saved.SaveState(&buf); err != nil {
+    t.Fatal(err)
+  }
+  // a state cut off before END fails after reading the RNG section
+  state := buf.Bytes()[:buf.Len() - 8]
+
+  cpu := NewCPU(WithRandSource(rand.NewChaCha8([32]byte{2})))
+  cpu.LoadRom([]uint8{0x12, 0x00})
+  twin := NewCPU(WithRandSource(rand.NewChaCha8([32]byte{2})))
+  if err := cpu.LoadState(bytes.NewReader(state)); err == nil {
+    t.Fatal("Expected a truncated state to fail")
+  }
+  if got, want := randomBytes(&cpu, 8), randomBytes(&twin, 8); string(got) != string(want) {
+    t.Errorf("Failed load changed the random source. Got %v, wanted %v", got, want)
+  }
+}
+
+func TestStateUnknownSection(t *testing.T) {
+  cpu := NewCPU()
+  cpu.LoadRom([]uint8{0x12, 0x00})
+  cpu.v[3] = 0x33
+  var buf bytes.Buffer
+  cpu.SaveState(&buf)
+
+  // splice a section from some future version in front of the others
+  header := len(stateMagic) + 2 + 20
+  state := append([]byte(nil), buf.Bytes()[:header]...)
+  state = append(state, 'F', 'U', 'T', 'R', 0, 0, 0, 3, 1, 2, 3)
+  state = append(state, buf.Bytes()[header:]...)
+
+  restored := NewCPU()
+  restored.LoadRom([]uint8{0x12, 0x00})
+  if err := restored.LoadState(bytes.NewReader(state)); err != nil {
+    t.Fatal(err)
+  }
+  checkReg(&restored, 3, 0x33, t)
+}
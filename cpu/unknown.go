@@ -0,0 +1,54 @@
+package cpu
+
+// UnknownOpcodePolicy decides what the CPU does with an instruction it does
+// not implement.
+type UnknownOpcodePolicy int
+
+const (
+  // HaltOnUnknown stops execution with an InvalidOpcode fault.
+  HaltOnUnknown UnknownOpcodePolicy = iota
+  // SkipUnknown logs the instruction and carries on with the next one.
+  SkipUnknown
+  // HandleUnknown passes the instruction to an UnknownOpcodeHandler.
+  HandleUnknown
+)
+
+// UnknownOpcodeHandler is called for instructions the CPU does not
+// implement. Returning nil skips the instruction; returning an error halts
+// the CPU with that error and leaves the PC on the instruction.
+type UnknownOpcodeHandler func(cpu *CPU, instruction uint16) error
+
+// WithUnknownOpcodes sets the policy for unimplemented instructions. The
+// default is HaltOnUnknown.
+func WithUnknownOpcodes(policy UnknownOpcodePolicy) Option {
+  return func(cpu *CPU) {
+    cpu.unknown = policy
+  }
+}
+
+// WithUnknownOpcodeHandler hands unimplemented instructions to h.
+func WithUnknownOpcodeHandler(h UnknownOpcodeHandler) Option {
+  return func(cpu *CPU) {
+    cpu.unknown = HandleUnknown
+    cpu.unknownHandler = h
+  }
+}
+
+func (cpu *CPU) unknownOpcode(instruction uint16) error {
+  switch cpu.unknown {
+    case SkipUnknown:
+      cpu.logger.Warn("skipping unknown opcode", "pc", cpu.pc, "opcode", instruction)
+    case HandleUnknown:
+      if cpu.unknownHandler != nil {
+        if err := cpu.unknownHandler(cpu, instruction); err != nil {
+          return err
+        }
+        break
+      }
+      return cpu.fault(InvalidOpcode, instruction)
+    default:
+      return cpu.fault(InvalidOpcode, instruction)
+  }
+  cpu.pc += 2
+  return nil
+}
@@ -0,0 +1,40 @@
+package cpu
+
+import (
+  "errors"
+  "testing"
+)
+
+func TestUnknownSkip(t *testing.T) {
+  cpu := NewCPU(WithUnknownOpcodes(SkipUnknown))
+  if err := cpu.executeInstruction(0x8018); err != nil {
+    t.Errorf("Unexpected fault %v", err)
+  }
+  checkPC(&cpu, 0x202, t)
+}
+
+func TestUnknownHandler(t *testing.T) {
+  var seen []uint16
+  stop := errors.New("stop")
+  cpu := NewCPU(WithUnknownOpcodeHandler(func(cpu *CPU, instruction uint16) error {
+    seen = append(seen, instruction)
+    if instruction == 0xf0ff {
+      return stop
+    }
+    return nil
+  }))
+
+  if err := cpu.executeInstruction(0x0123); err != nil {
+    t.Errorf("Unexpected fault %v", err)
+  }
+  checkPC(&cpu, 0x202, t)
+
+  if err := cpu.executeInstruction(0xf0ff); err != stop {
+    t.Errorf("Expected handler error, got %v", err)
+  }
+  checkPC(&cpu, 0x202, t)
+
+  if len(seen) != 2 || seen[0] != 0x0123 || seen[1] != 0xf0ff {
+    t.Errorf("Incorrect handled instructions %x", seen)
+  }
+}
@@ -0,0 +1,90 @@
+package cpu
+
+// AccessKind is the kind of memory access a watch is told about. Kinds
+// can be or'ed together when registering a watch.
+type AccessKind int
+
+const (
+  // AccessRead is data read by FX65, 5XY3, F002 and the sprite data of
+  // DXYN.
+  AccessRead AccessKind = 1 << iota
+  // AccessWrite is data written by FX33, FX55 and 5XY2.
+  AccessWrite
+  // AccessFetch is an instruction fetch, including the second word of
+  // F000 NNNN.
+  AccessFetch
+)
+
+func (k AccessKind) String() string {
+  switch k {
+    case AccessRead:
+      return "read"
+    case AccessWrite:
+      return "write"
+    case AccessFetch:
+      return "fetch"
+  }
+  return "unknown"
+}
+
+// Access describes one byte of memory being touched. For writes Value is
+// the new value. PC is the address of the instruction doing the access.
+type Access struct {
+  Kind  AccessKind
+  Addr  uint16
+  Value uint8
+  PC    uint16
+}
+
+// WatchFunc is called after a watched access has happened.
+type WatchFunc func(cpu *CPU, a Access)
+
+type watch struct {
+  id     int
+  lo, hi uint16
+  kinds  AccessKind
+  fn     WatchFunc
+}
+
+// Watch calls fn for every access of the given kinds to addresses lo
+// through hi inclusive, and returns an id for Unwatch. While any watch is
+// registered the block cache is bypassed so that every access is seen;
+// without watches there is no overhead.
+func (cpu *CPU) Watch(lo, hi uint16, kinds AccessKind, fn WatchFunc) int {
+  cpu.watchID++
+  cpu.watches = append(cpu.watches, watch{cpu.watchID, lo, hi, kinds, fn})
+  return cpu.watchID
+}
+
+// Unwatch removes the watch with the given id.
+func (cpu *CPU) Unwatch(id int) {
+  for j, w := range cpu.watches {
+    if w.id == id {
+      cpu.watches = append(cpu.watches[:j:j], cpu.watches[j + 1:]...)
+      break
+    }
+  }
+  if len(cpu.watches) == 0 {
+    cpu.watches = nil
+  }
+}
+
+// accessed reports n bytes at addr to the watches.
+func (cpu *CPU) accessed(kind AccessKind, addr uint16, n int) {
+  if cpu.watches == nil {
+    return
+  }
+  cpu.notifyWatches(kind, addr, n)
+}
+
+func (cpu *CPU) notifyWatches(kind AccessKind, addr uint16, n int) {
+  pc := cpu.pc
+  for j := 0; j < n; j++ {
+    a := addr + uint16(j)
+    for _, w := range cpu.watches {
+      if w.kinds & kind != 0 && a >= w.lo && a <= w.hi {
+        w.fn(cpu, Access{kind, a, cpu.memory[a], pc})
+      }
+    }
+  }
+}
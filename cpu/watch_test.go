@@ -0,0 +1,70 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestWatchWrite(t *testing.T) {
+  cpu := NewCPU()
+  var got []Access
+  cpu.Watch(0x301, 0x302, AccessWrite, func(cpu *CPU, a Access) {
+    got = append(got, a)
+  })
+  cpu.pc = 0x210
+  cpu.i = 0x300
+  cpu.setRegister(0, 123)
+  cpu.executeInstruction(0xf033) /* LD B, V0 */
+
+  want := []Access{{AccessWrite, 0x301, 2, 0x210}, {AccessWrite, 0x302, 3, 0x210}}
+  if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+    t.Errorf("Incorrect accesses. Got %v, wanted %v", got, want)
+  }
+
+  got = nil
+  cpu.executeInstruction(0xf065) /* LD V0, [I] */
+  if len(got) != 0 {
+    t.Errorf("Write watch saw a read: %v", got)
+  }
+}
+
+func TestWatchRead(t *testing.T) {
+  cpu := NewCPU()
+  reads := map[AccessKind]int{}
+  cpu.Watch(0x300, 0x3ff, AccessRead | AccessFetch, func(cpu *CPU, a Access) {
+    reads[a.Kind]++
+  })
+  cpu.i = 0x300
+  cpu.executeInstruction(0xf265) /* LD V2, [I] */
+  cpu.executeInstruction(0xd005) /* DRW V0, V0, 5 */
+  if reads[AccessRead] != 8 {
+    t.Errorf("Incorrect number of reads. Got %v, wanted 8", reads[AccessRead])
+  }
+
+  cpu.pc = 0x300
+  cpu.RunCycle()
+  if reads[AccessFetch] != 2 {
+    t.Errorf("Incorrect number of fetches. Got %v, wanted 2", reads[AccessFetch])
+  }
+}
+
+func TestUnwatch(t *testing.T) {
+  cpu := NewCPU(WithBlockCache())
+  count := 0
+  id := cpu.Watch(0x200, 0x201, AccessFetch, func(cpu *CPU, a Access) {
+    count++
+  })
+  cpu.LoadRom([]uint8{0x12, 0x00}) /* JP 0x200 */
+  if cpu.useBlocks() {
+    t.Errorf("Expected the block cache to be bypassed while watching")
+  }
+  cpu.RunFrame(3)
+  if count != 6 {
+    t.Errorf("Incorrect number of fetches. Got %v, wanted 6", count)
+  }
+
+  cpu.Unwatch(id)
+  cpu.RunFrame(3)
+  if count != 6 || !cpu.useBlocks() {
+    t.Errorf("Watch still active after Unwatch")
+  }
+}
@@ -0,0 +1,76 @@
+package cpu
+
+// Plane is bitplane n (0 or 1) of the framebuffer, Width() pixels per row
+// and Height() rows. Only XO-CHIP ROMs draw to plane 1.
+func (cpu *CPU) Plane(n int) []bool {
+  return cpu.planes[n][:cpu.Width()*cpu.Height()]
+}
+
+// Colors composites both bitplanes into dst as color indices 0-3, bit 0
+// from plane 0 and bit 1 from plane 1, and returns the extended slice.
+func (cpu *CPU) Colors(dst []uint8) []uint8 {
+  size := cpu.Width()*cpu.Height()
+  for i := 0; i < size; i++ {
+    var c uint8
+    if cpu.planes[0][i] {
+      c |= 1
+    }
+    if cpu.planes[1][i] {
+      c |= 2
+    }
+    dst = append(dst, c)
+  }
+  return dst
+}
+
+// AudioPattern is the 16-byte, 1-bit sample buffer loaded by F002.
+func (cpu *CPU) AudioPattern() [16]uint8 {
+  return cpu.pattern
+}
+
+// Pitch is the playback rate of the audio pattern set by FX3A.
+func (cpu *CPU) Pitch() uint8 {
+  return cpu.pitch
+}
+
+// skip steps over the next instruction, which is four bytes long if it is
+// an XO-CHIP F000 NNNN.
+func (cpu *CPU) skip() {
+  cpu.pc += 2
+  if cpu.platform < PlatformXOCHIP || int(cpu.pc) + 1 >= len(cpu.memory) {
+    return
+  }
+  if cpu.memory[cpu.pc] == 0xF0 && cpu.memory[cpu.pc + 1] == 0x00 {
+    cpu.pc += 2
+  }
+}
+
+// saveLoadRange implements 5XY2 and 5XY3, which store or load VX through
+// VY at I without changing I. X may be greater than Y, in which case the
+// registers are visited in descending order.
+func (cpu *CPU) saveLoadRange(ins Instruction) error {
+  x, y := int(ins.X), int(ins.Y)
+  step, count := 1, y - x + 1
+  if x > y {
+    step, count = -1, x - y + 1
+  }
+  if int(cpu.i) + count > len(cpu.memory) {
+    return cpu.fault(MemoryOutOfBounds, ins.Opcode)
+  }
+  for j := 0; j < count; j++ {
+    addr := cpu.i + uint16(j)
+    reg := uint8(x + j*step)
+    if ins.Kind == KindSAVE {
+      cpu.memory[addr] = cpu.getRegister(reg)
+    } else {
+      cpu.setRegister(reg, cpu.memory[addr])
+    }
+  }
+  if ins.Kind == KindSAVE {
+    cpu.wrote(cpu.i, count)
+    cpu.accessed(AccessWrite, cpu.i, count)
+  } else {
+    cpu.accessed(AccessRead, cpu.i, count)
+  }
+  return nil
+}
@@ -0,0 +1,92 @@
+package cpu
+
+import (
+  "testing"
+)
+
+func TestXochipMemory(t *testing.T) {
+  cpu := NewCPU()
+  if len(cpu.memory) != 0x1000 {
+    t.Errorf("Incorrect CHIP-8 memory size %#x", len(cpu.memory))
+  }
+
+  cpu = NewCPU(WithPlatform(PlatformXOCHIP))
+  if len(cpu.memory) != 0x10000 {
+    t.Errorf("Incorrect XO-CHIP memory size %#x", len(cpu.memory))
+  }
+  cpu.LoadRom([]uint8{0xf0, 0x00, 0xbe, 0xef})
+  cpu.RunCycle()
+  checkI(&cpu, 0xbeef, t)
+  checkPC(&cpu, 0x204, t)
+
+  cpu.setRegister(0, 7)
+  cpu.executeInstruction(0xf033)
+  checkMem(&cpu, 0xbef1, 7, t)
+}
+
+func TestXochipSkipLong(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformXOCHIP))
+  cpu.LoadRom([]uint8{0x30, 0x00, 0xf0, 0x00, 0x12, 0x34})
+  cpu.RunCycle()
+  checkPC(&cpu, 0x206, t)
+
+  cpu = NewCPU(WithPlatform(PlatformSCHIP))
+  cpu.LoadRom([]uint8{0x30, 0x00, 0xf0, 0x00, 0x12, 0x34})
+  cpu.RunCycle()
+  checkPC(&cpu, 0x204, t)
+}
+
+func TestXochipSaveLoadRange(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformXOCHIP))
+  cpu.i = 0x300
+  cpu.setRegister(2, 0xaa)
+  cpu.setRegister(3, 0xbb)
+  cpu.setRegister(4, 0xcc)
+
+  cpu.executeInstruction(0x5242)
+  checkMem(&cpu, 0x300, 0xaa, t)
+  checkMem(&cpu, 0x302, 0xcc, t)
+  checkI(&cpu, 0x300, t)
+
+  cpu.executeInstruction(0x5732) /* descending */
+  checkMem(&cpu, 0x303, 0xcc, t)
+  checkMem(&cpu, 0x304, 0xbb, t)
+
+  cpu.i = 0x303
+  cpu.executeInstruction(0x5a93)
+  checkReg(&cpu, 0xa, 0xcc, t)
+  checkReg(&cpu, 0x9, 0xbb, t)
+}
+
+func TestXochipPlanes(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformXOCHIP))
+  cpu.i = 0x300
+  cpu.memory[0x300] = 0x80 /* plane 0 */
+  cpu.memory[0x301] = 0xc0 /* plane 1 */
+
+  cpu.executeInstruction(0xf301)
+  cpu.executeInstruction(0xd011)
+  colors := cpu.Colors(nil)
+  if colors[0] != 3 || colors[1] != 2 || colors[2] != 0 {
+    t.Errorf("Incorrect colors %v", colors[:3])
+  }
+  if !cpu.Plane(0)[0] || cpu.Plane(0)[1] || !cpu.Plane(1)[1] {
+    t.Errorf("Incorrect planes")
+  }
+
+  cpu.executeInstruction(0xf201) /* clear only plane 1 */
+  cpu.executeInstruction(0x00e0)
+  colors = cpu.Colors(colors[:0])
+  if colors[0] != 1 || colors[1] != 0 {
+    t.Errorf("Incorrect colors after clear %v", colors[:2])
+  }
+}
+
+func TestXochipScrollUp(t *testing.T) {
+  cpu := NewCPU(WithPlatform(PlatformXOCHIP))
+  cpu.planes[0][3 + 5*64] = true
+  cpu.executeInstruction(0x00d2)
+  if !cpu.Display()[3 + 3*64] || cpu.Display()[3 + 5*64] {
+    t.Errorf("Expected 00D2 to scroll up 2 rows")
+  }
+}
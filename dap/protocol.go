@@ -0,0 +1,147 @@
+package dap
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/textproto"
+  "strconv"
+)
+
+// request is a message from the client. Only requests are expected; the
+// server never sends reverse requests.
+type request struct {
+  Seq       int             `json:"seq"`
+  Type      string          `json:"type"`
+  Command   string          `json:"command"`
+  Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+  Seq        int    `json:"seq"`
+  Type       string `json:"type"`
+  RequestSeq int    `json:"request_seq"`
+  Success    bool   `json:"success"`
+  Command    string `json:"command"`
+  Message    string `json:"message,omitempty"`
+  Body       any    `json:"body,omitempty"`
+}
+
+type event struct {
+  Seq   int    `json:"seq"`
+  Type  string `json:"type"`
+  Event string `json:"event"`
+  Body  any    `json:"body,omitempty"`
+}
+
+// readMessage reads one message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+  header, err := textproto.NewReader(r).ReadMIMEHeader()
+  if err != nil {
+    return nil, err
+  }
+  length, err := strconv.Atoi(header.Get("Content-Length"))
+  if err != nil || length < 0 {
+    return nil, fmt.Errorf("dap: bad Content-Length %q", header.Get("Content-Length"))
+  }
+  body := make([]byte, length)
+  if _, err := io.ReadFull(r, body); err != nil {
+    return nil, err
+  }
+  return body, nil
+}
+
+// writeMessage writes v as JSON framed by a Content-Length header.
+func writeMessage(w io.Writer, v any) error {
+  body, err := json.Marshal(v)
+  if err != nil {
+    return err
+  }
+  if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+    return err
+  }
+  _, err = w.Write(body)
+  return err
+}
+
+// The argument and body types below cover the subset of the protocol the
+// server implements.
+
+type launchArguments struct {
+  Program     string `json:"program"`
+  Symbols     string `json:"symbols"`
+  Platform    string `json:"platform"`
+  Quirks      string `json:"quirks"`
+  Cycles      int    `json:"cycles"`
+  Seed        uint64 `json:"seed"`
+  StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type source struct {
+  Name string `json:"name,omitempty"`
+  Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+  Line int `json:"line"`
+}
+
+type setBreakpointsArguments struct {
+  Source      source             `json:"source"`
+  Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type instructionBreakpoint struct {
+  InstructionReference string `json:"instructionReference"`
+  Offset               int    `json:"offset"`
+}
+
+type setInstructionBreakpointsArguments struct {
+  Breakpoints []instructionBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+  ID                   int     `json:"id,omitempty"`
+  Verified             bool    `json:"verified"`
+  Message              string  `json:"message,omitempty"`
+  Source               *source `json:"source,omitempty"`
+  Line                 int     `json:"line,omitempty"`
+  InstructionReference string  `json:"instructionReference,omitempty"`
+}
+
+type stackFrame struct {
+  ID                          int     `json:"id"`
+  Name                        string  `json:"name"`
+  Source                      *source `json:"source,omitempty"`
+  Line                        int     `json:"line"`
+  Column                      int     `json:"column"`
+  InstructionPointerReference string  `json:"instructionPointerReference"`
+}
+
+type scope struct {
+  Name               string `json:"name"`
+  VariablesReference int    `json:"variablesReference"`
+  Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+  Name               string `json:"name"`
+  Value              string `json:"value"`
+  VariablesReference int    `json:"variablesReference"`
+}
+
+type disassembleArguments struct {
+  MemoryReference   string `json:"memoryReference"`
+  InstructionOffset int    `json:"instructionOffset"`
+  InstructionCount  int    `json:"instructionCount"`
+}
+
+type disassembledInstruction struct {
+  Address          string  `json:"address"`
+  InstructionBytes string  `json:"instructionBytes"`
+  Instruction      string  `json:"instruction"`
+  Location         *source `json:"location,omitempty"`
+  Line             int     `json:"line,omitempty"`
+  PresentationHint string  `json:"presentationHint,omitempty"`
+}
@@ -0,0 +1,511 @@
+// Package dap serves the Debug Adapter Protocol, so that editors such as
+// VS Code and Neovim can debug ROMs. It wraps a debugger.Session and, when
+// the assembler left a symbol map next to the ROM, maps addresses to
+// source lines.
+package dap
+
+import (
+  "bufio"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+
+  "cryp-8/cpu"
+  "cryp-8/debugger"
+  "cryp-8/romdb"
+  "cryp-8/symbols"
+)
+
+// The only thread, and the variables references of the two scopes.
+const (
+  threadID     = 1
+  registersRef = 1
+  stackRef     = 2
+)
+
+// Server is one debugging session with one client.
+type Server struct {
+  in  *bufio.Reader
+  out io.Writer
+  wmu sync.Mutex // serializes writes and seq
+  seq int
+
+  // mu is held while the CPU runs. Requests that inspect it fail rather
+  // than wait, so that pause is always read; breakpoints go through the
+  // session, which changes them between frames.
+  mu           sync.Mutex
+  cpu          cpu.CPU
+  session      *debugger.Session
+  symbols      *symbols.Map
+  stopOnEntry  bool
+  sourceBreaks map[string][]int
+  addrBreaks   []int
+}
+
+// Serve speaks the protocol over rw until the client disconnects or the
+// connection closes.
+func Serve(rw io.ReadWriter) error {
+  s := &Server{
+    in:           bufio.NewReader(rw),
+    out:          rw,
+    sourceBreaks: map[string][]int{},
+  }
+  return s.serve()
+}
+
+func (s *Server) serve() error {
+  for {
+    body, err := readMessage(s.in)
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    var req request
+    if err := json.Unmarshal(body, &req); err != nil {
+      return fmt.Errorf("dap: bad message: %w", err)
+    }
+    if req.Type != "request" {
+      continue
+    }
+    if done := s.handle(&req); done {
+      return nil
+    }
+  }
+}
+
+func (s *Server) send(v any) {
+  s.wmu.Lock()
+  defer s.wmu.Unlock()
+  s.seq++
+  switch m := v.(type) {
+    case *response:
+      m.Seq = s.seq
+    case *event:
+      m.Seq = s.seq
+  }
+  writeMessage(s.out, v)
+}
+
+func (s *Server) respond(req *request, body any, err error) {
+  r := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+  if err != nil {
+    r.Message = err.Error()
+  }
+  s.send(r)
+}
+
+func (s *Server) event(name string, body any) {
+  s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+var (
+  errNotLaunched = errors.New("no program has been launched")
+  errRunning     = errors.New("the program is running")
+)
+
+// handle answers one request and reports whether the session is over.
+func (s *Server) handle(req *request) bool {
+  switch req.Command {
+    case "initialize":
+      s.respond(req, map[string]any{
+        "supportsConfigurationDoneRequest": true,
+        "supportsInstructionBreakpoints":   true,
+        "supportsDisassembleRequest":       true,
+      }, nil)
+    case "launch":
+      var args launchArguments
+      err := json.Unmarshal(req.Arguments, &args)
+      if err == nil {
+        err = s.launch(args)
+      }
+      s.respond(req, nil, err)
+      if err == nil {
+        s.event("initialized", nil)
+      }
+    case "configurationDone":
+      s.respond(req, nil, nil)
+      if s.session == nil {
+        break
+      }
+      if s.stopOnEntry {
+        s.event("stopped", map[string]any{"reason": "entry", "threadId": threadID, "allThreadsStopped": true})
+        break
+      }
+      s.mu.Lock()
+      s.resume(s.session.Continue)
+    case "setBreakpoints":
+      var args setBreakpointsArguments
+      json.Unmarshal(req.Arguments, &args)
+      body, err := s.setBreakpoints(args)
+      s.respond(req, body, err)
+    case "setInstructionBreakpoints":
+      var args setInstructionBreakpointsArguments
+      json.Unmarshal(req.Arguments, &args)
+      body, err := s.setInstructionBreakpoints(args)
+      s.respond(req, body, err)
+    case "threads":
+      s.respond(req, map[string]any{"threads": []map[string]any{{"id": threadID, "name": "cpu"}}}, nil)
+    case "stackTrace":
+      body, err := s.stackTrace()
+      s.respond(req, body, err)
+    case "scopes":
+      s.respond(req, map[string]any{"scopes": []scope{
+        {"Registers", registersRef, false},
+        {"Stack", stackRef, false},
+      }}, nil)
+    case "variables":
+      var args struct {
+        VariablesReference int `json:"variablesReference"`
+      }
+      json.Unmarshal(req.Arguments, &args)
+      body, err := s.variables(args.VariablesReference)
+      s.respond(req, body, err)
+    case "disassemble":
+      var args disassembleArguments
+      json.Unmarshal(req.Arguments, &args)
+      body, err := s.disassemble(args)
+      s.respond(req, body, err)
+    case "continue":
+      s.step(req, func() debugger.Stop { return s.session.Continue() })
+    case "next":
+      s.step(req, func() debugger.Stop { return s.session.StepOver() })
+    case "stepIn":
+      s.step(req, func() debugger.Stop { return s.session.Step(1) })
+    case "stepOut":
+      s.step(req, func() debugger.Stop { return s.session.StepOut() })
+    case "pause":
+      if s.session != nil {
+        s.session.Interrupt()
+      }
+      s.respond(req, nil, nil)
+    case "disconnect", "terminate":
+      if s.session != nil {
+        s.session.Interrupt()
+      }
+      s.mu.Lock()
+      s.mu.Unlock()
+      s.respond(req, nil, nil)
+      return req.Command == "disconnect"
+    default:
+      s.respond(req, nil, fmt.Errorf("unsupported request %q", req.Command))
+  }
+  return false
+}
+
+// launch configures the machine like the other commands do: from the ROM
+// database for a known ROM, otherwise cpu.DefaultPlatform with its quirks,
+// with the arguments overriding either.
+func (s *Server) launch(args launchArguments) error {
+  if !s.mu.TryLock() {
+    return errRunning
+  }
+  defer s.mu.Unlock()
+  rom, err := os.ReadFile(args.Program)
+  if err != nil {
+    return err
+  }
+  platform, quirks, cycles := cpu.DefaultPlatform, cpu.Quirks{}, 10
+  game, known := romdb.Default().Lookup(rom)
+  if known {
+    platform, quirks = game.Platform, game.Quirks
+    if game.Tickrate > 0 {
+      cycles = game.Tickrate
+    }
+  }
+  if args.Platform != "" {
+    var ok bool
+    if platform, ok = cpu.ParsePlatform(args.Platform); !ok {
+      return fmt.Errorf("unknown platform %q", args.Platform)
+    }
+  }
+  if args.Quirks != "" {
+    var ok bool
+    if quirks, ok = cpu.QuirksPreset(args.Quirks); !ok {
+      return fmt.Errorf("unknown quirks preset %q", args.Quirks)
+    }
+  } else if !known {
+    quirks = cpu.QuirksFor(platform)
+  }
+  opts := []cpu.Option{cpu.WithPlatform(platform), cpu.WithQuirks(quirks)}
+  if args.Seed != 0 {
+    opts = append(opts, cpu.WithSeed(args.Seed))
+  }
+  if args.Cycles == 0 {
+    args.Cycles = cycles
+  }
+
+  // the symbol map is optional; without it breakpoints are by address
+  path := args.Symbols
+  if path == "" {
+    path = args.Program + ".sym"
+  }
+  if f, err := os.Open(path); err == nil {
+    s.symbols, err = symbols.Read(f)
+    f.Close()
+    if err != nil {
+      return fmt.Errorf("reading symbols %v: %w", path, err)
+    }
+  } else if args.Symbols != "" {
+    return err
+  }
+
+  s.cpu = cpu.NewCPU(opts...)
+  s.cpu.LoadRom(rom)
+  s.session = debugger.NewSession(&s.cpu, args.Cycles)
+  s.stopOnEntry = args.StopOnEntry
+  return nil
+}
+
+// step answers an execution request and runs the CPU in the background.
+func (s *Server) step(req *request, run func() debugger.Stop) {
+  if s.session == nil {
+    s.respond(req, nil, errNotLaunched)
+    return
+  }
+  if !s.mu.TryLock() {
+    s.respond(req, nil, errRunning)
+    return
+  }
+  s.respond(req, map[string]any{"allThreadsContinued": true}, nil)
+  s.resume(run)
+}
+
+// resume runs the CPU on another goroutine, so that pause can be read,
+// and reports how it stopped. s.mu must be held and is released when the
+// CPU stops.
+func (s *Server) resume(run func() debugger.Stop) {
+  go func() {
+    stop := run()
+    s.mu.Unlock()
+    s.stopped(stop)
+  }()
+}
+
+func (s *Server) stopped(stop debugger.Stop) {
+  body := map[string]any{"threadId": threadID, "allThreadsStopped": true}
+  switch stop.Reason {
+    case debugger.StopExited:
+      s.event("exited", map[string]any{"exitCode": 0})
+      s.event("terminated", nil)
+      return
+    case debugger.StopBreakpoint:
+      body["reason"] = "breakpoint"
+      body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+    case debugger.StopFault:
+      body["reason"] = "exception"
+      body["description"] = stop.Err.Error()
+      body["text"] = stop.Err.Error()
+    case debugger.StopInterrupt:
+      body["reason"] = "pause"
+    default:
+      body["reason"] = "step"
+  }
+  s.event("stopped", body)
+}
+
+func (s *Server) setBreakpoints(args setBreakpointsArguments) (any, error) {
+  if s.session == nil {
+    return nil, errNotLaunched
+  }
+  for _, id := range s.sourceBreaks[args.Source.Path] {
+    s.session.Delete(id)
+  }
+  s.sourceBreaks[args.Source.Path] = nil
+  result := []breakpoint{}
+  for _, b := range args.Breakpoints {
+    if s.symbols == nil {
+      result = append(result, breakpoint{Verified: false, Line: b.Line, Message: "no symbol map for this ROM"})
+      continue
+    }
+    l, ok := s.symbols.Address(args.Source.Path, b.Line)
+    if !ok {
+      result = append(result, breakpoint{Verified: false, Line: b.Line, Message: "no code at or after this line"})
+      continue
+    }
+    bp, err := s.session.Break(address(l.Addr))
+    if err != nil {
+      return nil, err
+    }
+    s.sourceBreaks[args.Source.Path] = append(s.sourceBreaks[args.Source.Path], bp.ID)
+    result = append(result, breakpoint{ID: bp.ID, Verified: true, Source: &args.Source, Line: l.Line, InstructionReference: address(l.Addr)})
+  }
+  return map[string]any{"breakpoints": result}, nil
+}
+
+func (s *Server) setInstructionBreakpoints(args setInstructionBreakpointsArguments) (any, error) {
+  if s.session == nil {
+    return nil, errNotLaunched
+  }
+  for _, id := range s.addrBreaks {
+    s.session.Delete(id)
+  }
+  s.addrBreaks = nil
+  result := []breakpoint{}
+  for _, b := range args.Breakpoints {
+    addr, err := parseAddress(b.InstructionReference)
+    if err != nil {
+      result = append(result, breakpoint{Verified: false, Message: err.Error()})
+      continue
+    }
+    addr += b.Offset
+    bp, err := s.session.Break(address(uint16(addr)))
+    if err != nil {
+      return nil, err
+    }
+    s.addrBreaks = append(s.addrBreaks, bp.ID)
+    result = append(result, breakpoint{ID: bp.ID, Verified: true, InstructionReference: address(uint16(addr))})
+  }
+  return map[string]any{"breakpoints": result}, nil
+}
+
+// stackTrace shows the PC as the top frame and each return address on the
+// stack below it.
+func (s *Server) stackTrace() (any, error) {
+  if s.session == nil {
+    return nil, errNotLaunched
+  }
+  if !s.mu.TryLock() {
+    return nil, errRunning
+  }
+  defer s.mu.Unlock()
+  r := s.cpu.Registers()
+  addrs := []uint16{r.PC}
+  for j := int(r.SP) - 1; j >= 0; j-- {
+    addrs = append(addrs, r.Stack[j])
+  }
+  frames := []stackFrame{}
+  for j, addr := range addrs {
+    f := stackFrame{ID: j, Name: address(addr), InstructionPointerReference: address(addr)}
+    if s.symbols != nil {
+      if name, at, ok := s.symbols.Label(addr); ok {
+        f.Name = name
+        if at != addr {
+          f.Name = fmt.Sprintf("%s+%d", name, addr - at)
+        }
+      }
+      if l, ok := s.symbols.Source(addr); ok {
+        f.Source = &source{Name: l.File, Path: l.File}
+        f.Line, f.Column = l.Line, 1
+      }
+    }
+    frames = append(frames, f)
+  }
+  return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *Server) variables(ref int) (any, error) {
+  if s.session == nil {
+    return nil, errNotLaunched
+  }
+  if !s.mu.TryLock() {
+    return nil, errRunning
+  }
+  defer s.mu.Unlock()
+  r := s.cpu.Registers()
+  vars := []variable{}
+  switch ref {
+    case registersRef:
+      for j, v := range r.V {
+        vars = append(vars, variable{Name: fmt.Sprintf("V%X", j), Value: fmt.Sprintf("0x%02X", v)})
+      }
+      vars = append(vars,
+        variable{Name: "I", Value: fmt.Sprintf("0x%04X", r.I)},
+        variable{Name: "PC", Value: fmt.Sprintf("0x%04X", r.PC)},
+        variable{Name: "SP", Value: fmt.Sprintf("%d", r.SP)},
+        variable{Name: "DT", Value: fmt.Sprintf("%d", r.DT)},
+        variable{Name: "ST", Value: fmt.Sprintf("%d", r.ST)},
+      )
+    case stackRef:
+      for j := 0; j < int(r.SP); j++ {
+        vars = append(vars, variable{Name: fmt.Sprintf("%d", j), Value: fmt.Sprintf("0x%04X", r.Stack[j])})
+      }
+    default:
+      return nil, fmt.Errorf("unknown variables reference %d", ref)
+  }
+  return map[string]any{"variables": vars}, nil
+}
+
+// disassemble decodes from 0x200, or from 0 for a reference below it, so
+// that counting back from the reference steps over F000 NNNN correctly.
+// Instructions outside memory are filled in as invalid, since the client
+// expects exactly the count it asked for.
+func (s *Server) disassemble(args disassembleArguments) (any, error) {
+  if s.session == nil {
+    return nil, errNotLaunched
+  }
+  if !s.mu.TryLock() {
+    return nil, errRunning
+  }
+  defer s.mu.Unlock()
+  addr, err := parseAddress(args.MemoryReference)
+  if err != nil {
+    return nil, err
+  }
+  start := 0
+  if addr >= 0x200 {
+    start = 0x200
+  }
+  var lines []debugger.Line
+  for a := start; a < addr; {
+    l := s.session.Disassemble(uint16(a), 1)
+    if len(l) == 0 {
+      break
+    }
+    lines = append(lines, l[0])
+    a += len(l[0].Bytes)
+  }
+  first := len(lines) + args.InstructionOffset
+  lines = append(lines, s.session.Disassemble(uint16(addr), max(args.InstructionOffset + args.InstructionCount, 0))...)
+  end := addr
+  if len(lines) > 0 {
+    last := lines[len(lines) - 1]
+    end = max(end, int(last.Addr) + len(last.Bytes))
+  }
+
+  list := []disassembledInstruction{}
+  for j := first; j < first + args.InstructionCount; j++ {
+    if j < 0 || j >= len(lines) {
+      // count on from the first or last decoded instruction
+      a := start + 2*j
+      if j >= len(lines) {
+        a = end + 2*(j - len(lines))
+      }
+      a = min(max(a, 0), 0xFFFF)
+      list = append(list, disassembledInstruction{Address: address(uint16(a)), PresentationHint: "invalid"})
+      continue
+    }
+    l := lines[j]
+    d := disassembledInstruction{
+      Address:          address(l.Addr),
+      InstructionBytes: strings.TrimSpace(fmt.Sprintf("% X", l.Bytes)),
+      Instruction:      l.Text,
+    }
+    if s.symbols != nil {
+      if src, ok := s.symbols.Source(l.Addr); ok {
+        d.Location = &source{Name: src.File, Path: src.File}
+        d.Line = src.Line
+      }
+    }
+    list = append(list, d)
+  }
+  return map[string]any{"instructions": list}, nil
+}
+
+func address(addr uint16) string {
+  return fmt.Sprintf("0x%04X", addr)
+}
+
+func parseAddress(s string) (int, error) {
+  n, err := strconv.ParseUint(s, 0, 16)
+  if err != nil {
+    return 0, fmt.Errorf("bad address %q", s)
+  }
+  return int(n), nil
+}
@@ -0,0 +1,268 @@
+package dap
+
+import (
+  "bufio"
+  "encoding/json"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "cryp-8/symbols"
+)
+
+var program = []uint8{
+  0x60, 0x00, /* 200: LD V0, 0 */
+  0x22, 0x08, /* 202: CALL 0x208 */
+  0x70, 0x01, /* 204: ADD V0, 1 */
+  0x12, 0x02, /* 206: JP 0x202 */
+  0x61, 0x05, /* 208: LD V1, 5 */
+  0x00, 0xee, /* 20A: RET */
+}
+
+// client drives a Server over a pipe, the way an editor would.
+type client struct {
+  t      *testing.T
+  in     *bufio.Reader
+  out    io.Writer
+  seq    int
+  events []map[string]any
+  done   chan error
+}
+
+func newClient(t *testing.T) *client {
+  serverIn, clientOut := io.Pipe()
+  clientIn, serverOut := io.Pipe()
+  c := &client{t: t, in: bufio.NewReader(clientIn), out: clientOut, done: make(chan error, 1)}
+  go func() {
+    c.done <- Serve(struct {
+      io.Reader
+      io.Writer
+    }{serverIn, serverOut})
+    serverOut.Close()
+  }()
+  return c
+}
+
+func (c *client) read() map[string]any {
+  c.t.Helper()
+  body, err := readMessage(c.in)
+  if err != nil {
+    c.t.Fatal(err)
+  }
+  var m map[string]any
+  if err := json.Unmarshal(body, &m); err != nil {
+    c.t.Fatal(err)
+  }
+  return m
+}
+
+// request sends a request and returns the body of its response, which
+// must succeed.
+func (c *client) request(command string, args any) map[string]any {
+  c.t.Helper()
+  m := c.call(command, args)
+  if m["success"] != true {
+    c.t.Fatalf("%v failed: %v", command, m["message"])
+  }
+  body, _ := m["body"].(map[string]any)
+  return body
+}
+
+// call sends a request and returns its response, keeping any events that
+// arrive first.
+func (c *client) call(command string, args any) map[string]any {
+  c.t.Helper()
+  c.seq++
+  writeMessage(c.out, map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+  for {
+    m := c.read()
+    if m["type"] == "event" {
+      c.events = append(c.events, m)
+      continue
+    }
+    if m["request_seq"] != float64(c.seq) {
+      c.t.Fatalf("Response for the wrong request: %v", m)
+    }
+    return m
+  }
+}
+
+// wait returns the body of the next event with the given name.
+func (c *client) wait(name string) map[string]any {
+  c.t.Helper()
+  for {
+    var m map[string]any
+    if len(c.events) > 0 {
+      m, c.events = c.events[0], c.events[1:]
+    } else {
+      m = c.read()
+    }
+    if m["type"] == "event" && m["event"] == name {
+      body, _ := m["body"].(map[string]any)
+      return body
+    }
+  }
+}
+
+func (c *client) pc() string {
+  c.t.Helper()
+  frames := c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any)
+  return frames[0].(map[string]any)["instructionPointerReference"].(string)
+}
+
+func writeROM(t *testing.T) string {
+  dir := t.TempDir()
+  path := filepath.Join(dir, "game.ch8")
+  if err := os.WriteFile(path, program, 0644); err != nil {
+    t.Fatal(err)
+  }
+  m := &symbols.Map{
+    Lines: []symbols.Line{
+      {File: "game.8o", Line: 1, Addr: 0x200},
+      {File: "game.8o", Line: 2, Addr: 0x202},
+      {File: "game.8o", Line: 3, Addr: 0x204},
+      {File: "game.8o", Line: 4, Addr: 0x206},
+      {File: "game.8o", Line: 7, Addr: 0x208},
+      {File: "game.8o", Line: 8, Addr: 0x20a},
+    },
+    Labels: map[string]uint16{"main": 0x200, "sub": 0x208},
+  }
+  f, _ := os.Create(path + ".sym")
+  m.Write(f)
+  f.Close()
+  return path
+}
+
+func TestSession(t *testing.T) {
+  c := newClient(t)
+  caps := c.request("initialize", map[string]any{"adapterID": "cryp-8"})
+  if caps["supportsConfigurationDoneRequest"] != true {
+    t.Errorf("Incorrect capabilities %v", caps)
+  }
+  c.request("launch", map[string]any{"program": writeROM(t), "platform": "chip8", "stopOnEntry": true})
+  c.wait("initialized")
+
+  bps := c.request("setBreakpoints", map[string]any{
+    "source":      map[string]any{"path": "/src/game.8o"},
+    "breakpoints": []map[string]any{{"line": 6}},
+  })["breakpoints"].([]any)
+  if bp := bps[0].(map[string]any); bp["verified"] != true || bp["line"] != float64(7) {
+    t.Errorf("Incorrect breakpoint %v", bp)
+  }
+  c.request("configurationDone", nil)
+  if body := c.wait("stopped"); body["reason"] != "entry" {
+    t.Errorf("Incorrect stop %v", body)
+  }
+
+  c.request("continue", map[string]any{"threadId": 1})
+  if body := c.wait("stopped"); body["reason"] != "breakpoint" {
+    t.Errorf("Incorrect stop %v", body)
+  }
+  frames := c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any)
+  top := frames[0].(map[string]any)
+  if len(frames) != 2 || top["name"] != "sub" || top["line"] != float64(7) {
+    t.Errorf("Incorrect stack trace %v", frames)
+  }
+
+  c.request("stepOut", map[string]any{"threadId": 1})
+  c.wait("stopped")
+  if pc := c.pc(); pc != "0x0204" {
+    t.Errorf("Incorrect PC after step out. Got %v, wanted 0x0204", pc)
+  }
+  c.request("stepIn", map[string]any{"threadId": 1})
+  c.wait("stopped")
+
+  vars := c.request("variables", map[string]any{"variablesReference": registersRef})["variables"].([]any)
+  if v := vars[0].(map[string]any); v["name"] != "V0" || v["value"] != "0x01" {
+    t.Errorf("Incorrect V0 %v", v)
+  }
+  if v := vars[1].(map[string]any); v["value"] != "0x05" {
+    t.Errorf("Incorrect V1 %v", v)
+  }
+
+  c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": "/src/game.8o"}, "breakpoints": []any{}})
+  c.request("setInstructionBreakpoints", map[string]any{"breakpoints": []map[string]any{{"instructionReference": "0x0206"}}})
+  c.request("continue", map[string]any{"threadId": 1})
+  c.wait("stopped")
+  if pc := c.pc(); pc != "0x0206" {
+    t.Errorf("Incorrect PC at instruction breakpoint. Got %v, wanted 0x0206", pc)
+  }
+
+  dis := c.request("disassemble", map[string]any{"memoryReference": "0x0200", "instructionCount": 2})["instructions"].([]any)
+  if d := dis[1].(map[string]any); d["instruction"] != "CALL 0x208" || d["line"] != float64(2) {
+    t.Errorf("Incorrect disassembly %v", d)
+  }
+
+  // breakpoints set while running take effect without stopping first
+  c.request("setInstructionBreakpoints", map[string]any{"breakpoints": []any{}})
+  c.request("continue", map[string]any{"threadId": 1})
+  c.request("setInstructionBreakpoints", map[string]any{"breakpoints": []map[string]any{{"instructionReference": "0x020A"}}})
+  if body := c.wait("stopped"); body["reason"] != "breakpoint" {
+    t.Errorf("Incorrect stop %v", body)
+  }
+  if pc := c.pc(); pc != "0x020A" {
+    t.Errorf("Incorrect PC at breakpoint set while running. Got %v, wanted 0x020A", pc)
+  }
+
+  // run forever, changing breakpoints to ones never reached, then pause
+  c.request("setInstructionBreakpoints", map[string]any{"breakpoints": []any{}})
+  c.request("continue", map[string]any{"threadId": 1})
+  c.request("setInstructionBreakpoints", map[string]any{"breakpoints": []map[string]any{{"instructionReference": "0x0300"}}})
+  c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": "/src/game.8o"}, "breakpoints": []any{}})
+  if r := c.call("launch", map[string]any{"program": "other.ch8"}); r["success"] != false || r["message"] != "the program is running" {
+    t.Errorf("Expected launch to be refused while running, got %v", r)
+  }
+  c.request("pause", map[string]any{"threadId": 1})
+  if body := c.wait("stopped"); body["reason"] != "pause" {
+    t.Errorf("Incorrect stop %v", body)
+  }
+
+  c.request("disconnect", nil)
+  if err := <-c.done; err != nil {
+    t.Error(err)
+  }
+}
+
+func TestDisassemble(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "long.ch8")
+  rom := []uint8{
+    0x00, 0xe0, /* 200: CLS */
+    0xf0, 0x00, 0x12, 0x34, /* 202: LD I, 0x1234 */
+    0x00, 0xe0, /* 206: CLS */
+  }
+  if err := os.WriteFile(path, rom, 0644); err != nil {
+    t.Fatal(err)
+  }
+  c := newClient(t)
+  c.request("initialize", map[string]any{"adapterID": "cryp-8"})
+  c.request("launch", map[string]any{"program": path, "platform": "xochip"})
+  c.wait("initialized")
+
+  for _, tt := range []struct {
+    ref    string
+    offset int
+    want   []string
+  }{
+    // counting back steps over the four byte instruction
+    {"0x0206", -2, []string{"0x0200 CLS", "0x0202 LD I, 0x1234", "0x0206 CLS", "0x0208 DW 0x0000"}},
+    {"0x0200", -1, []string{"0x01FE invalid", "0x0200 CLS"}},
+    {"0xFFFE", 0, []string{"0xFFFE DW 0x0000", "0xFFFF invalid", "0xFFFF invalid"}},
+  } {
+    dis := c.request("disassemble", map[string]any{"memoryReference": tt.ref, "instructionOffset": tt.offset, "instructionCount": len(tt.want)})["instructions"].([]any)
+    var got []string
+    for _, d := range dis {
+      d := d.(map[string]any)
+      text, _ := d["instruction"].(string)
+      if d["presentationHint"] == "invalid" {
+        text = "invalid"
+      }
+      got = append(got, d["address"].(string) + " " + text)
+    }
+    if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+      t.Errorf("Incorrect disassembly from %v%+d. Got %v, wanted %v", tt.ref, tt.offset, got, tt.want)
+    }
+  }
+  c.request("disconnect", nil)
+}
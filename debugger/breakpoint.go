@@ -0,0 +1,199 @@
+package debugger
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "cryp-8/cpu"
+)
+
+// Breakpoint stops execution before an instruction when its condition
+// holds.
+type Breakpoint struct {
+  ID   int
+  // Spec is the text the breakpoint was made from.
+  Spec string
+
+  match func(c *cpu.CPU) bool
+  // edge breakpoints only fire when their condition becomes true, so
+  // that a value that stays the same doesn't stop every instruction
+  edge bool
+  last bool
+}
+
+// ParseBreakpoint makes a breakpoint from one of
+//
+//   0x2A4           the PC reaches an address
+//   op D??5         the next opcode matches; ?, x, y, n and k match any
+//                   nibble
+//   V3 == 5         a register changes to satisfy a comparison; the left
+//                   side can be V0-VF, I, PC, SP, DT, ST or [addr] for a
+//                   byte of memory, the operator one of == != < <= > >=
+//
+// Numbers are decimal unless prefixed with 0x or $.
+func ParseBreakpoint(spec string) (*Breakpoint, error) {
+  spec = strings.TrimSpace(spec)
+  fields := strings.Fields(spec)
+  b := &Breakpoint{Spec: spec}
+  switch {
+    case len(fields) == 1:
+      addr, err := parseNumber(fields[0], 16)
+      if err != nil {
+        return nil, err
+      }
+      b.match = func(c *cpu.CPU) bool {
+        return c.Registers().PC == uint16(addr)
+      }
+    case len(fields) == 2 && fields[0] == "op":
+      mask, value, err := parsePattern(fields[1])
+      if err != nil {
+        return nil, err
+      }
+      b.match = func(c *cpu.CPU) bool {
+        return opcode(c) & mask == value
+      }
+    case len(fields) == 3:
+      get, err := parseOperand(fields[0])
+      if err != nil {
+        return nil, err
+      }
+      compare, err := parseComparison(fields[1])
+      if err != nil {
+        return nil, err
+      }
+      want, err := parseNumber(fields[2], 16)
+      if err != nil {
+        return nil, err
+      }
+      b.match = func(c *cpu.CPU) bool {
+        return compare(get(c), int(want))
+      }
+      b.edge = true
+    default:
+      return nil, fmt.Errorf("bad breakpoint %q", spec)
+  }
+  return b, nil
+}
+
+// hit reports whether the breakpoint fires for the CPU's current state.
+func (b *Breakpoint) hit(c *cpu.CPU) bool {
+  m := b.match(c)
+  if !b.edge {
+    return m
+  }
+  fired := m && !b.last
+  b.last = m
+  return fired
+}
+
+func opcode(c *cpu.CPU) uint16 {
+  pc := c.Registers().PC
+  b := c.Peek(pc, 2)
+  if len(b) < 2 {
+    return 0
+  }
+  return uint16(b[0]) << 8 | uint16(b[1])
+}
+
+// parsePattern turns an opcode pattern such as D??5 into a mask and the
+// value the masked opcode must have.
+func parsePattern(s string) (uint16, uint16, error) {
+  if len(s) != 4 {
+    return 0, 0, fmt.Errorf("opcode pattern %q is not 4 nibbles", s)
+  }
+  var mask, value uint16
+  for _, r := range strings.ToLower(s) {
+    mask, value = mask << 4, value << 4
+    switch {
+      case strings.ContainsRune("?xynk", r):
+      case strings.ContainsRune("0123456789abcdef", r):
+        n, _ := strconv.ParseUint(string(r), 16, 4)
+        mask |= 0xF
+        value |= uint16(n)
+      default:
+        return 0, 0, fmt.Errorf("bad opcode pattern %q", s)
+    }
+  }
+  return mask, value, nil
+}
+
+// parseOperand returns a function that reads the named register or
+// memory byte.
+func parseOperand(s string) (func(c *cpu.CPU) int, error) {
+  upper := strings.ToUpper(s)
+  if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+    addr, err := parseNumber(s[1:len(s) - 1], 16)
+    if err != nil {
+      return nil, err
+    }
+    return func(c *cpu.CPU) int {
+      b := c.Peek(uint16(addr), 1)
+      if len(b) == 0 {
+        return -1
+      }
+      return int(b[0])
+    }, nil
+  }
+  if reg, ok := parseRegister(s); ok {
+    return func(c *cpu.CPU) int {
+      return int(c.Registers().V[reg])
+    }, nil
+  }
+  switch upper {
+    case "I":
+      return func(c *cpu.CPU) int { return int(c.Registers().I) }, nil
+    case "PC":
+      return func(c *cpu.CPU) int { return int(c.Registers().PC) }, nil
+    case "SP":
+      return func(c *cpu.CPU) int { return int(c.Registers().SP) }, nil
+    case "DT":
+      return func(c *cpu.CPU) int { return int(c.Registers().DT) }, nil
+    case "ST":
+      return func(c *cpu.CPU) int { return int(c.Registers().ST) }, nil
+  }
+  return nil, fmt.Errorf("unknown register %q", s)
+}
+
+// parseRegister parses V0 through VF.
+func parseRegister(s string) (uint8, bool) {
+  if len(s) != 2 || (s[0] != 'V' && s[0] != 'v') {
+    return 0, false
+  }
+  n, err := strconv.ParseUint(s[1:], 16, 4)
+  return uint8(n), err == nil
+}
+
+func parseComparison(s string) (func(a, b int) bool, error) {
+  switch s {
+    case "==":
+      return func(a, b int) bool { return a == b }, nil
+    case "!=":
+      return func(a, b int) bool { return a != b }, nil
+    case "<":
+      return func(a, b int) bool { return a < b }, nil
+    case "<=":
+      return func(a, b int) bool { return a <= b }, nil
+    case ">":
+      return func(a, b int) bool { return a > b }, nil
+    case ">=":
+      return func(a, b int) bool { return a >= b }, nil
+  }
+  return nil, fmt.Errorf("unknown comparison %q", s)
+}
+
+// parseNumber parses a number of up to bits bits: hex if prefixed with 0x
+// or $, otherwise decimal, even with a leading 0.
+func parseNumber(s string, bits int) (uint64, error) {
+  digits, base := s, 10
+  if h, ok := strings.CutPrefix(s, "0x"); ok {
+    digits, base = h, 16
+  } else if h, ok := strings.CutPrefix(s, "$"); ok {
+    digits, base = h, 16
+  }
+  n, err := strconv.ParseUint(digits, base, bits)
+  if err != nil {
+    return 0, fmt.Errorf("bad number %q", s)
+  }
+  return n, nil
+}
@@ -0,0 +1,279 @@
+package debugger
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+)
+
+const help = `commands:
+  break <spec>           add a breakpoint: 0x2A4, op D??5 or V3 == 5
+                         numbers are decimal, or hex after 0x or $
+  breaks                 list breakpoints
+  delete <id>            remove a breakpoint
+  step [n], s [n]        run n instructions (default 1)
+  next, n                step over a 2NNN call
+  finish, f              run until the current subroutine returns
+  continue, c            run until a breakpoint; Ctrl-C interrupts
+  regs, r                show registers, stack, timers and keys
+  list [addr] [n], l     disassemble around the PC or at addr
+  x <addr> [n]           dump n bytes of memory
+  poke <addr> <byte>...  write bytes to memory
+  set <reg> <value>      set V0-VF, I, PC, SP, DT or ST
+  key down|up <k>        press or release a key
+  screen                 print the display
+  quit, q                leave the debugger
+`
+
+// REPL reads commands from in and writes their results to out until quit
+// or the end of input.
+func REPL(s *Session, in io.Reader, out io.Writer) error {
+  scanner := bufio.NewScanner(in)
+  fmt.Fprintln(out, "cryp-8 debugger, type help for commands")
+  s.printLocation(out)
+  last := ""
+  for {
+    fmt.Fprint(out, "(c8) ")
+    if !scanner.Scan() {
+      fmt.Fprintln(out)
+      return scanner.Err()
+    }
+    line := strings.TrimSpace(scanner.Text())
+    // an empty line repeats the last command, like gdb
+    if line == "" {
+      line = last
+    }
+    last = line
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+      continue
+    }
+    if fields[0] == "quit" || fields[0] == "q" {
+      return nil
+    }
+    // forget a Ctrl-C typed at the prompt
+    s.interrupted.Store(false)
+    if err := s.command(out, fields[0], fields[1:], line); err != nil {
+      fmt.Fprintln(out, "error:", err)
+    }
+  }
+}
+
+func (s *Session) command(out io.Writer, cmd string, args []string, line string) error {
+  switch cmd {
+    case "help", "h":
+      fmt.Fprint(out, help)
+    case "break", "b":
+      b, err := s.Break(strings.TrimSpace(strings.TrimPrefix(line, cmd)))
+      if err != nil {
+        return err
+      }
+      fmt.Fprintf(out, "breakpoint %d: %s\n", b.ID, b.Spec)
+    case "breaks":
+      for _, b := range s.Breakpoints() {
+        fmt.Fprintf(out, "%d: %s\n", b.ID, b.Spec)
+      }
+    case "delete", "d":
+      if len(args) != 1 {
+        return fmt.Errorf("usage: delete <id>")
+      }
+      id, err := strconv.Atoi(args[0])
+      if err != nil {
+        return err
+      }
+      return s.Delete(id)
+    case "step", "s":
+      n := 1
+      if len(args) > 0 {
+        v, err := parseNumber(args[0], 32)
+        if err != nil {
+          return err
+        }
+        n = int(v)
+      }
+      s.printStop(out, s.Step(n))
+    case "next", "n":
+      s.printStop(out, s.StepOver())
+    case "finish", "f":
+      s.printStop(out, s.StepOut())
+    case "continue", "c":
+      s.printStop(out, s.Continue())
+    case "regs", "r":
+      s.printRegisters(out)
+    case "list", "l":
+      pc := s.CPU.Registers().PC
+      addr, n := pc, 10
+      if pc >= 0x208 {
+        addr = pc - 8
+      }
+      if len(args) > 0 {
+        v, err := parseNumber(args[0], 16)
+        if err != nil {
+          return err
+        }
+        addr = uint16(v)
+      }
+      if len(args) > 1 {
+        v, err := parseNumber(args[1], 16)
+        if err != nil {
+          return err
+        }
+        n = int(v)
+      }
+      for _, l := range s.Disassemble(addr, n) {
+        marker := "  "
+        if l.Addr == pc {
+          marker = "=>"
+        }
+        fmt.Fprintf(out, "%s %04X  % -12X %s\n", marker, l.Addr, l.Bytes, l.Text)
+      }
+    case "x":
+      if len(args) < 1 {
+        return fmt.Errorf("usage: x <addr> [n]")
+      }
+      addr, err := parseNumber(args[0], 16)
+      if err != nil {
+        return err
+      }
+      n := uint64(16)
+      if len(args) > 1 {
+        if n, err = parseNumber(args[1], 16); err != nil {
+          return err
+        }
+      }
+      data := s.CPU.Peek(uint16(addr), int(n))
+      for j := 0; j < len(data); j += 16 {
+        fmt.Fprintf(out, "%04X  % X\n", int(addr) + j, data[j:min(j + 16, len(data))])
+      }
+    case "poke":
+      if len(args) < 2 {
+        return fmt.Errorf("usage: poke <addr> <byte>...")
+      }
+      addr, err := parseNumber(args[0], 16)
+      if err != nil {
+        return err
+      }
+      var data []uint8
+      for _, a := range args[1:] {
+        b, err := parseNumber(a, 8)
+        if err != nil {
+          return err
+        }
+        data = append(data, uint8(b))
+      }
+      s.CPU.Poke(uint16(addr), data)
+    case "set":
+      if len(args) != 2 {
+        return fmt.Errorf("usage: set <reg> <value>")
+      }
+      return s.set(args[0], args[1])
+    case "key":
+      if len(args) != 2 {
+        return fmt.Errorf("usage: key down|up <k>")
+      }
+      k, err := strconv.ParseUint(args[1], 16, 4)
+      if err != nil {
+        return fmt.Errorf("bad key %q", args[1])
+      }
+      switch args[0] {
+        case "down":
+          s.CPU.KeyDown(uint8(k))
+        case "up":
+          s.CPU.KeyUp(uint8(k))
+        default:
+          return fmt.Errorf("usage: key down|up <k>")
+      }
+    case "screen":
+      w, h := s.CPU.Width(), s.CPU.Height()
+      colors := s.CPU.Colors(nil)
+      for y := 0; y < h; y++ {
+        var b strings.Builder
+        for x := 0; x < w; x++ {
+          b.WriteByte(" #+*"[colors[y*w + x]])
+        }
+        fmt.Fprintln(out, strings.TrimRight(b.String(), " "))
+      }
+    default:
+      return fmt.Errorf("unknown command %q, type help", cmd)
+  }
+  return nil
+}
+
+// set changes one register.
+func (s *Session) set(name, value string) error {
+  r := s.CPU.Registers()
+  bits := 16
+  reg, isV := parseRegister(name)
+  if isV {
+    bits = 8
+  }
+  v, err := parseNumber(value, bits)
+  if err != nil {
+    return err
+  }
+  switch strings.ToUpper(name) {
+    case "I":
+      r.I = uint16(v)
+    case "PC":
+      r.PC = uint16(v)
+    case "SP":
+      r.SP = uint8(v)
+    case "DT":
+      r.DT = uint8(v)
+    case "ST":
+      r.ST = uint8(v)
+    default:
+      if !isV {
+        return fmt.Errorf("unknown register %q", name)
+      }
+      r.V[reg] = uint8(v)
+  }
+  s.CPU.SetRegisters(r)
+  return nil
+}
+
+func (s *Session) printStop(out io.Writer, stop Stop) {
+  switch stop.Reason {
+    case StopBreakpoint:
+      fmt.Fprintf(out, "breakpoint %d: %s\n", stop.Breakpoint.ID, stop.Breakpoint.Spec)
+    case StopFault:
+      fmt.Fprintln(out, "stopped:", stop.Err)
+    case StopExited:
+      fmt.Fprintln(out, "the program exited")
+    case StopInterrupt:
+      fmt.Fprintln(out, "interrupted")
+  }
+  s.printLocation(out)
+}
+
+func (s *Session) printLocation(out io.Writer) {
+  l := s.Disassemble(s.CPU.Registers().PC, 1)
+  if len(l) == 1 {
+    fmt.Fprintf(out, "%04X  %s\n", l[0].Addr, l[0].Text)
+  }
+}
+
+func (s *Session) printRegisters(out io.Writer) {
+  r := s.CPU.Registers()
+  fmt.Fprintf(out, "PC %04X  I %04X  SP %d  DT %d  ST %d\n", r.PC, r.I, r.SP, r.DT, r.ST)
+  for j, v := range r.V {
+    sep := " "
+    if j % 8 == 7 {
+      sep = "\n"
+    }
+    fmt.Fprintf(out, "V%X %02X%s", j, v, sep)
+  }
+  fmt.Fprint(out, "stack")
+  for j := 0; j < int(r.SP); j++ {
+    fmt.Fprintf(out, " %04X", r.Stack[j])
+  }
+  fmt.Fprint(out, "\nkeys ")
+  for k, down := range r.Keys {
+    if down {
+      fmt.Fprintf(out, " %X", k)
+    }
+  }
+  fmt.Fprintln(out)
+}
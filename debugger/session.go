@@ -0,0 +1,249 @@
+// Package debugger steps and inspects a running CPU. Session is the core
+// that frontends such as the command-line REPL drive.
+package debugger
+
+import (
+  "fmt"
+  "sync"
+  "sync/atomic"
+
+  "cryp-8/cpu"
+)
+
+// StopReason says why a Session stopped running.
+type StopReason int
+
+const (
+  // StopStep means the requested steps were run.
+  StopStep StopReason = iota
+  // StopBreakpoint means a breakpoint fired.
+  StopBreakpoint
+  // StopFault means the CPU returned an error.
+  StopFault
+  // StopExited means the ROM ran 00FD.
+  StopExited
+  // StopInterrupt means Interrupt was called.
+  StopInterrupt
+)
+
+func (r StopReason) String() string {
+  switch r {
+    case StopStep:
+      return "step"
+    case StopBreakpoint:
+      return "breakpoint"
+    case StopFault:
+      return "fault"
+    case StopExited:
+      return "exited"
+    case StopInterrupt:
+      return "interrupted"
+  }
+  return "unknown"
+}
+
+// Stop describes where and why execution stopped.
+type Stop struct {
+  Reason     StopReason
+  PC         uint16
+  Breakpoint *Breakpoint
+  Err        error
+}
+
+// Session runs a CPU one instruction at a time, ticking its timers every
+// Cycles instructions like RunFrame would. Breakpoints can be changed from
+// another goroutine while it runs; the change is made between frames.
+type Session struct {
+  CPU    *cpu.CPU
+  Cycles int
+
+  // mu guards the breakpoints. A run holds it, letting go between frames.
+  mu          sync.Mutex
+  breakpoints []*Breakpoint
+  nextID      int
+  count       int
+  interrupted atomic.Bool
+}
+
+// NewSession debugs c, which runs cycles instructions per frame.
+func NewSession(c *cpu.CPU, cycles int) *Session {
+  if cycles < 1 {
+    cycles = 1
+  }
+  return &Session{CPU: c, Cycles: cycles}
+}
+
+// Break adds a breakpoint; see ParseBreakpoint for the syntax.
+func (s *Session) Break(spec string) (*Breakpoint, error) {
+  b, err := ParseBreakpoint(spec)
+  if err != nil {
+    return nil, err
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.nextID++
+  b.ID = s.nextID
+  b.last = b.match(s.CPU)
+  s.breakpoints = append(s.breakpoints, b)
+  return b, nil
+}
+
+// Delete removes the breakpoint with the given id.
+func (s *Session) Delete(id int) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for j, b := range s.breakpoints {
+    if b.ID == id {
+      s.breakpoints = append(s.breakpoints[:j], s.breakpoints[j + 1:]...)
+      return nil
+    }
+  }
+  return fmt.Errorf("no breakpoint %d", id)
+}
+
+// ClearBreakpoints removes every breakpoint.
+func (s *Session) ClearBreakpoints() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.breakpoints = nil
+}
+
+// Breakpoints lists the breakpoints in the order they were added.
+func (s *Session) Breakpoints() []*Breakpoint {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]*Breakpoint(nil), s.breakpoints...)
+}
+
+// Interrupt stops a running Continue, StepOver or StepOut before the next
+// instruction. If nothing is running, the next run stops after its first
+// instruction. It is safe to call from another goroutine.
+func (s *Session) Interrupt() {
+  s.interrupted.Store(true)
+}
+
+// Step runs n instructions, stopping early at a breakpoint.
+func (s *Session) Step(n int) Stop {
+  return s.run(n, nil)
+}
+
+// StepOver runs one instruction, or if it is a 2NNN call, runs until the
+// subroutine returns.
+func (s *Session) StepOver() Stop {
+  ins := s.Instruction()
+  if ins.Kind != cpu.KindCALL {
+    return s.Step(1)
+  }
+  sp := s.CPU.Registers().SP
+  return s.run(0, func() bool {
+    return s.CPU.Registers().SP <= sp
+  })
+}
+
+// StepOut runs until the current subroutine returns with 00EE.
+func (s *Session) StepOut() Stop {
+  sp := s.CPU.Registers().SP
+  if sp == 0 {
+    return Stop{Reason: StopFault, PC: s.CPU.Registers().PC, Err: fmt.Errorf("not in a subroutine")}
+  }
+  return s.run(0, func() bool {
+    return s.CPU.Registers().SP < sp
+  })
+}
+
+// Continue runs until a breakpoint, a fault, the ROM exiting or Interrupt.
+func (s *Session) Continue() Stop {
+  return s.run(0, nil)
+}
+
+// Instruction decodes the instruction at the PC.
+func (s *Session) Instruction() cpu.Instruction {
+  return cpu.Decode(opcode(s.CPU))
+}
+
+// run executes up to limit instructions, or without limit if it is zero,
+// until done reports true. Breakpoints are checked before every
+// instruction but the first, so that continuing from a breakpoint moves
+// on. An interrupt is cleared when the run ends rather than when it
+// starts, so one that arrives just before a run still stops it.
+func (s *Session) run(limit int, done func() bool) Stop {
+  defer s.interrupted.Store(false)
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for n := 0; limit == 0 || n < limit; n++ {
+    pc := s.CPU.Registers().PC
+    if n > 0 {
+      if done != nil && done() {
+        return Stop{Reason: StopStep, PC: pc}
+      }
+      if b := s.hit(); b != nil {
+        return Stop{Reason: StopBreakpoint, PC: pc, Breakpoint: b}
+      }
+      if s.interrupted.Swap(false) {
+        return Stop{Reason: StopInterrupt, PC: pc}
+      }
+    }
+    if s.CPU.Exited() {
+      return Stop{Reason: StopExited, PC: pc}
+    }
+    if err := s.cycle(); err != nil {
+      return Stop{Reason: StopFault, PC: s.CPU.Registers().PC, Err: err}
+    }
+  }
+  return Stop{Reason: StopStep, PC: s.CPU.Registers().PC}
+}
+
+// hit checks every breakpoint, so that edge-triggered ones see every
+// state, and returns the first that fired.
+func (s *Session) hit() *Breakpoint {
+  var fired *Breakpoint
+  for _, b := range s.breakpoints {
+    if b.hit(s.CPU) && fired == nil {
+      fired = b
+    }
+  }
+  return fired
+}
+
+func (s *Session) cycle() error {
+  if err := s.CPU.RunCycle(); err != nil {
+    return err
+  }
+  s.count++
+  // a CPU waiting for the display would only idle out the frame
+  if s.count >= s.Cycles || s.CPU.WaitingForDisplay() {
+    s.CPU.TickTimers()
+    s.count = 0
+    // let breakpoints change between frames
+    s.mu.Unlock()
+    s.mu.Lock()
+  }
+  return nil
+}
+
+// Line is one disassembled instruction.
+type Line struct {
+  Addr  uint16
+  Bytes []uint8
+  Text  string
+}
+
+// Disassemble decodes n instructions starting at addr with the same
+// decoder the CPU executes.
+func (s *Session) Disassemble(addr uint16, n int) []Line {
+  var lines []Line
+  // counting in an int stops at the end of memory rather than wrapping
+  for a := int(addr); n > 0 && a + 1 < s.CPU.MemorySize(); n-- {
+    addr = uint16(a)
+    b := s.CPU.Peek(addr, 4)
+    ins := cpu.Decode(uint16(b[0]) << 8 | uint16(b[1]))
+    text := ins.String()
+    if ins.Kind == cpu.KindLDILong && len(b) == 4 {
+      text = fmt.Sprintf("LD I, 0x%04X", uint16(b[2]) << 8 | uint16(b[3]))
+    }
+    size := min(ins.Size(), len(b))
+    lines = append(lines, Line{addr, b[:size], text})
+    a += size
+  }
+  return lines
+}
@@ -0,0 +1,154 @@
+package debugger
+
+import (
+  "strings"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+var program = []uint8{
+  0x60, 0x00, /* 200: LD V0, 0 */
+  0x22, 0x0a, /* 202: CALL 0x20A */
+  0x70, 0x01, /* 204: ADD V0, 1 */
+  0xd0, 0x05, /* 206: DRW V0, V0, 5 */
+  0x12, 0x02, /* 208: JP 0x202 */
+  0x61, 0x05, /* 20A: LD V1, 5 */
+  0x71, 0x01, /* 20C: ADD V1, 1 */
+  0x00, 0xee, /* 20E: RET */
+}
+
+func newSession() *Session {
+  c := cpu.NewCPU()
+  c.LoadRom(program)
+  return NewSession(&c, 10)
+}
+
+func checkStop(stop Stop, reason StopReason, pc uint16, t *testing.T) {
+  t.Helper()
+  if stop.Reason != reason || stop.PC != pc {
+    t.Errorf("Incorrect stop. Got %v at %#x, wanted %v at %#x (%v)", stop.Reason, stop.PC, reason, pc, stop.Err)
+  }
+}
+
+func TestStep(t *testing.T) {
+  s := newSession()
+  checkStop(s.Step(3), StopStep, 0x20c, t)
+  checkStop(s.StepOut(), StopStep, 0x204, t)
+  checkStop(s.StepOut(), StopFault, 0x204, t)
+
+  s.Step(3)
+  checkStop(s.StepOver(), StopStep, 0x204, t)
+  if v := s.CPU.Registers().V[1]; v != 6 {
+    t.Errorf("Subroutine did not run. Got V1 %v, wanted 6", v)
+  }
+}
+
+func TestBreakpoints(t *testing.T) {
+  s := newSession()
+  if _, err := s.Break("0x206"); err != nil {
+    t.Fatal(err)
+  }
+  checkStop(s.Continue(), StopBreakpoint, 0x206, t)
+  // continuing moves past the breakpoint and round the loop to it again
+  checkStop(s.Continue(), StopBreakpoint, 0x206, t)
+  if v := s.CPU.Registers().V[0]; v != 2 {
+    t.Errorf("Incorrect V0. Got %v, wanted 2", v)
+  }
+
+  s.ClearBreakpoints()
+  s.Break("op 00EE")
+  checkStop(s.Continue(), StopBreakpoint, 0x20e, t)
+
+  s.ClearBreakpoints()
+  b, _ := s.Break("V0 == 5")
+  stop := s.Continue()
+  checkStop(stop, StopBreakpoint, 0x206, t)
+  if stop.Breakpoint != b {
+    t.Errorf("Incorrect breakpoint %v", stop.Breakpoint)
+  }
+  // the condition still holds but only fires again once it changes
+  s.Break("0x204")
+  checkStop(s.Continue(), StopBreakpoint, 0x204, t)
+
+  s.ClearBreakpoints()
+  b, _ = s.Break("[0x300] != 0")
+  s.Break("V1 >= 0x10")
+  if err := s.Delete(b.ID); err != nil {
+    t.Error(err)
+  }
+  if len(s.Breakpoints()) != 1 {
+    t.Errorf("Delete did not remove a breakpoint")
+  }
+
+  for _, bad := range []string{"", "op D??", "V3 ~ 5", "W3 == 5", "0x10000", "op DXYG"} {
+    if _, err := s.Break(bad); err == nil {
+      t.Errorf("Expected an error for %q", bad)
+    }
+  }
+}
+
+func TestParseNumber(t *testing.T) {
+  for s, want := range map[string]uint64{"0x200": 0x200, "$2a4": 0x2a4, "0200": 200, "15": 15} {
+    if n, err := parseNumber(s, 16); err != nil || n != want {
+      t.Errorf("Incorrect number for %q. Got %v (%v), wanted %v", s, n, err, want)
+    }
+  }
+  for _, bad := range []string{"", "0o17", "1_0", "0x", "$", "0b11", "65536"} {
+    if _, err := parseNumber(bad, 16); err == nil {
+      t.Errorf("Expected an error for %q", bad)
+    }
+  }
+}
+
+func TestTimers(t *testing.T) {
+  s := newSession()
+  s.CPU.SetRegisters(cpu.Registers{PC: 0x200, DT: 5})
+  s.Step(25)
+  if dt := s.CPU.Registers().DT; dt != 3 {
+    t.Errorf("Incorrect delay timer. Got %v, wanted 3", dt)
+  }
+}
+
+func TestDisplayWait(t *testing.T) {
+  c := cpu.NewCPU(cpu.WithQuirks(cpu.QuirksVIP))
+  c.LoadRom(program)
+  s := NewSession(&c, 10)
+  // the draw at 0x206 waits for the display; the next step moves on
+  checkStop(s.Step(6), StopStep, 0x206, t)
+  checkStop(s.Step(1), StopStep, 0x208, t)
+  checkStop(s.Step(1), StopStep, 0x202, t)
+}
+
+func TestInterrupt(t *testing.T) {
+  s := newSession()
+  s.Interrupt()
+  checkStop(s.Continue(), StopInterrupt, 0x202, t)
+  s.Break("V0 == 200")
+  checkStop(s.Continue(), StopBreakpoint, 0x206, t)
+}
+
+func TestDisassemble(t *testing.T) {
+  s := newSession()
+  lines := s.Disassemble(0x200, 3)
+  want := []string{"LD V0, 0x00", "CALL 0x20A", "ADD V0, 0x01"}
+  for j, l := range lines {
+    if l.Text != want[j] || l.Addr != 0x200 + uint16(2*j) {
+      t.Errorf("Incorrect line %v. Got %04X %v, wanted %v", j, l.Addr, l.Text, want[j])
+    }
+  }
+}
+
+func TestREPL(t *testing.T) {
+  s := newSession()
+  in := strings.NewReader("break 0x20c\nc\nset V3 0x42\npoke 0x300 1 2\nx 0x300 2\nregs\n\nfoo\nq\n")
+  var out strings.Builder
+  if err := REPL(s, in, &out); err != nil {
+    t.Fatal(err)
+  }
+  for _, want := range []string{"breakpoint 1: 0x20c", "020C  ADD V1, 0x01", "0300  01 02", "V3 42", "unknown command"} {
+    if !strings.Contains(out.String(), want) {
+      t.Errorf("Output is missing %q:\n%v", want, out.String())
+    }
+  }
+}
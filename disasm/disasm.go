@@ -0,0 +1,360 @@
+// Package disasm turns ROMs back into Octo assembly. Code is told apart
+// from data by following every jump, call and skip from 0x200, decoding
+// with the same tables the CPU executes. The listing assembles back to the
+// same bytes: whatever can't be written as an Octo instruction, such as
+// code that overlaps other code, is written as raw bytes, and labels that
+// can't be placed are written as addresses.
+package disasm
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+
+  "cryp-8/cpu"
+)
+
+const start = 0x200
+
+// Listing is a disassembled ROM.
+type Listing struct {
+  rom      []byte
+  platform cpu.Platform
+  // ins marks the addresses, relative to start, where an instruction was
+  // decoded; code marks every byte that belongs to one.
+  ins    []bool
+  code   []bool
+  // sprite marks data bytes that I is pointed at, which are likely
+  // sprites.
+  sprite []bool
+  labels map[uint16]string
+  // indirect lists BNNN jumps, whose targets can't be followed.
+  indirect []uint16
+}
+
+// Disassemble separates code from data in rom, which is loaded at 0x200
+// and run on the given platform.
+func Disassemble(rom []byte, platform cpu.Platform) *Listing {
+  l := &Listing{
+    rom:      rom,
+    platform: platform,
+    ins:      make([]bool, len(rom)),
+    code:     make([]bool, len(rom)),
+    sprite:   make([]bool, len(rom)),
+    labels:   map[uint16]string{},
+  }
+  l.trace()
+  return l
+}
+
+// decode returns the instruction at addr and the 16-bit operand of
+// F000 NNNN. ok is false past the end of the ROM or for an instruction
+// the platform doesn't have.
+func (l *Listing) decode(addr uint16) (ins cpu.Instruction, long uint16, ok bool) {
+  j := int(addr) - start
+  if j < 0 || j + 1 >= len(l.rom) {
+    return ins, 0, false
+  }
+  ins = cpu.Decode(uint16(l.rom[j]) << 8 | uint16(l.rom[j + 1]))
+  if ins.Kind == cpu.KindUnknown || ins.Kind.Platform() > l.platform {
+    return ins, 0, false
+  }
+  if ins.Kind == cpu.KindLDILong {
+    if j + 3 >= len(l.rom) {
+      return ins, 0, false
+    }
+    long = uint16(l.rom[j + 2]) << 8 | uint16(l.rom[j + 3])
+  }
+  return ins, long, true
+}
+
+// trace follows the flow of control from 0x200, marking code and naming
+// the places that are jumped to, called or pointed at.
+func (l *Listing) trace() {
+  work := []uint16{start}
+  l.labels[start] = "main"
+  for len(work) > 0 {
+    addr := work[len(work) - 1]
+    work = work[:len(work) - 1]
+    for {
+      j := int(addr) - start
+      if j < 0 || j >= len(l.rom) || l.ins[j] {
+        break
+      }
+      ins, long, ok := l.decode(addr)
+      if !ok || l.overlaps(j, ins.Size()) {
+        break
+      }
+      l.ins[j] = true
+      for k := 0; k < ins.Size(); k++ {
+        l.code[j + k] = true
+      }
+      next := addr + uint16(ins.Size())
+
+      switch ins.Kind {
+        case cpu.KindJP:
+          l.label(ins.NNN, "label")
+          work = append(work, ins.NNN)
+        case cpu.KindCALL:
+          l.label(ins.NNN, "sub")
+          work = append(work, ins.NNN)
+        case cpu.KindLDI:
+          l.label(ins.NNN, "data")
+        case cpu.KindLDILong:
+          l.label(long, "data")
+        case cpu.KindJPV:
+          l.indirect = append(l.indirect, addr)
+        case cpu.KindSEImm, cpu.KindSNEImm, cpu.KindSEReg, cpu.KindSNEReg, cpu.KindSKP, cpu.KindSKNP:
+          skipped := next + 2
+          if n, _, ok := l.decode(next); ok && n.Kind == cpu.KindLDILong && l.platform >= cpu.PlatformXOCHIP {
+            skipped = next + 4
+          }
+          work = append(work, skipped)
+      }
+      if ins.Kind == cpu.KindJP || ins.Kind == cpu.KindJPV || ins.Kind == cpu.KindRET || ins.Kind == cpu.KindEXIT {
+        break
+      }
+      addr = next
+    }
+  }
+
+  // everything I is pointed at that didn't turn out to be code is data
+  for addr, name := range l.labels {
+    j := int(addr) - start
+    if j >= 0 && j < len(l.rom) && !l.code[j] && name[:4] == "data" {
+      for k := j; k < len(l.rom) && !l.code[k]; k++ {
+        l.sprite[k] = true
+        if k > j {
+          if _, ok := l.labels[uint16(start + k)]; ok {
+            break
+          }
+        }
+      }
+    }
+  }
+}
+
+// overlaps reports whether n bytes at j run into an instruction that
+// starts elsewhere, as happens when code is entered at an odd address.
+func (l *Listing) overlaps(j, n int) bool {
+  for k := j; k < j + n && k < len(l.code); k++ {
+    if l.code[k] {
+      return true
+    }
+  }
+  return false
+}
+
+// label names addr unless it already has a name. Calls win over jumps and
+// both win over data.
+func (l *Listing) label(addr uint16, kind string) {
+  name := fmt.Sprintf("%s_%04x", kind, addr)
+  old, ok := l.labels[addr]
+  switch {
+    case !ok:
+      l.labels[addr] = name
+    case old == "main":
+    case kind == "sub" || (kind == "label" && old[:4] == "data"):
+      l.labels[addr] = name
+  }
+}
+
+// IsCode reports whether the byte at addr was found to be part of an
+// instruction.
+func (l *Listing) IsCode(addr uint16) bool {
+  j := int(addr) - start
+  return j >= 0 && j < len(l.code) && l.code[j]
+}
+
+// Labels returns the generated labels by address.
+func (l *Listing) Labels() map[uint16]string {
+  labels := map[uint16]string{}
+  for addr, name := range l.labels {
+    if l.placeable(addr) {
+      labels[addr] = name
+    }
+  }
+  return labels
+}
+
+// placeable reports whether a label at addr can be written in the source:
+// inside the ROM and not in the middle of an instruction.
+func (l *Listing) placeable(addr uint16) bool {
+  j := int(addr) - start
+  return j >= 0 && j < len(l.rom) && (l.ins[j] || !l.code[j])
+}
+
+// name is how an address is written as an operand.
+func (l *Listing) name(addr uint16) string {
+  if name, ok := l.labels[addr]; ok && l.placeable(addr) {
+    return name
+  }
+  return fmt.Sprintf("0x%03X", addr)
+}
+
+// Write writes the listing as Octo source.
+func (l *Listing) Write(w io.Writer) error {
+  bw := bufio.NewWriter(w)
+  for _, addr := range l.indirect {
+    fmt.Fprintf(bw, "# jump0 at 0x%03X has targets that were not followed\n", addr)
+  }
+  for j := 0; j < len(l.rom); {
+    addr := uint16(start + j)
+    if name, ok := l.labels[addr]; ok && l.placeable(addr) {
+      fmt.Fprintf(bw, "\n: %s\n", name)
+    }
+    if l.ins[j] {
+      ins, long, _ := l.decode(addr)
+      fmt.Fprintf(bw, "  %s\n", l.octo(ins, long))
+      j += ins.Size()
+      continue
+    }
+    if l.sprite[j] {
+      b := l.rom[j]
+      fmt.Fprintf(bw, "  0x%02X  # %s\n", b, spriteRow(b))
+      j++
+      continue
+    }
+    // plain data, up to 8 bytes a line
+    n := 0
+    fmt.Fprint(bw, " ")
+    for ; j < len(l.rom) && n < 8 && !l.ins[j] && !l.sprite[j]; j, n = j + 1, n + 1 {
+      if _, ok := l.labels[uint16(start + j)]; ok && n > 0 {
+        break
+      }
+      fmt.Fprintf(bw, " 0x%02X", l.rom[j])
+    }
+    fmt.Fprintln(bw)
+  }
+  return bw.Flush()
+}
+
+func spriteRow(b byte) string {
+  row := make([]byte, 8)
+  for k := range row {
+    row[k] = '.'
+    if b & (0x80 >> k) != 0 {
+      row[k] = '#'
+    }
+  }
+  return string(row)
+}
+
+// octo formats an instruction in Octo syntax.
+func (l *Listing) octo(ins cpu.Instruction, long uint16) string {
+  x, y := fmt.Sprintf("v%x", ins.X), fmt.Sprintf("v%x", ins.Y)
+  nn := fmt.Sprintf("0x%02X", ins.NN)
+  switch ins.Kind {
+    case cpu.KindCLS:
+      return "clear"
+    case cpu.KindRET:
+      return "return"
+    case cpu.KindSCD:
+      return fmt.Sprintf("scroll-down %d", ins.N)
+    case cpu.KindSCU:
+      return fmt.Sprintf("scroll-up %d", ins.N)
+    case cpu.KindSCR:
+      return "scroll-right"
+    case cpu.KindSCL:
+      return "scroll-left"
+    case cpu.KindEXIT:
+      return "exit"
+    case cpu.KindLOW:
+      return "lores"
+    case cpu.KindHIGH:
+      return "hires"
+    case cpu.KindJP:
+      return "jump " + l.name(ins.NNN)
+    case cpu.KindCALL:
+      if _, ok := l.labels[ins.NNN]; ok && l.placeable(ins.NNN) {
+        return l.name(ins.NNN)
+      }
+      // Octo can only call labels
+      return fmt.Sprintf("0x%02X 0x%02X  # call 0x%03X", ins.Opcode >> 8, ins.Opcode & 0xFF, ins.NNN)
+    // Octo's if states when the next instruction runs, the opposite of
+    // when it is skipped
+    case cpu.KindSEImm:
+      return fmt.Sprintf("if %s != %s then", x, nn)
+    case cpu.KindSNEImm:
+      return fmt.Sprintf("if %s == %s then", x, nn)
+    case cpu.KindSEReg:
+      return fmt.Sprintf("if %s != %s then", x, y)
+    case cpu.KindSNEReg:
+      return fmt.Sprintf("if %s == %s then", x, y)
+    case cpu.KindSKP:
+      return fmt.Sprintf("if %s -key then", x)
+    case cpu.KindSKNP:
+      return fmt.Sprintf("if %s key then", x)
+    case cpu.KindSAVE:
+      return fmt.Sprintf("save %s - %s", x, y)
+    case cpu.KindLOAD:
+      return fmt.Sprintf("load %s - %s", x, y)
+    case cpu.KindLDImm:
+      return fmt.Sprintf("%s := %s", x, nn)
+    case cpu.KindADDImm:
+      return fmt.Sprintf("%s += %s", x, nn)
+    case cpu.KindLDReg:
+      return fmt.Sprintf("%s := %s", x, y)
+    case cpu.KindOR:
+      return fmt.Sprintf("%s |= %s", x, y)
+    case cpu.KindAND:
+      return fmt.Sprintf("%s &= %s", x, y)
+    case cpu.KindXOR:
+      return fmt.Sprintf("%s ^= %s", x, y)
+    case cpu.KindADDReg:
+      return fmt.Sprintf("%s += %s", x, y)
+    case cpu.KindSUB:
+      return fmt.Sprintf("%s -= %s", x, y)
+    case cpu.KindSHR:
+      return fmt.Sprintf("%s >>= %s", x, y)
+    case cpu.KindSUBN:
+      return fmt.Sprintf("%s =- %s", x, y)
+    case cpu.KindSHL:
+      return fmt.Sprintf("%s <<= %s", x, y)
+    case cpu.KindLDI:
+      return "i := " + l.name(ins.NNN)
+    case cpu.KindJPV:
+      return fmt.Sprintf("jump0 0x%03X", ins.NNN)
+    case cpu.KindRND:
+      return fmt.Sprintf("%s := random %s", x, nn)
+    case cpu.KindDRW:
+      return fmt.Sprintf("sprite %s %s %d", x, y, ins.N)
+    case cpu.KindLDILong:
+      return "i := long " + l.name(long)
+    case cpu.KindPLANE:
+      // Octo only has planes 0 to 3
+      if ins.X > 3 {
+        break
+      }
+      return fmt.Sprintf("plane %d", ins.X)
+    case cpu.KindAUDIO:
+      return "audio"
+    case cpu.KindLDVDT:
+      return x + " := delay"
+    case cpu.KindLDK:
+      return x + " := key"
+    case cpu.KindLDDT:
+      return "delay := " + x
+    case cpu.KindLDST:
+      return "buzzer := " + x
+    case cpu.KindADDI:
+      return "i += " + x
+    case cpu.KindLDF:
+      return "i := hex " + x
+    case cpu.KindLDHF:
+      return "i := bighex " + x
+    case cpu.KindLDB:
+      return "bcd " + x
+    case cpu.KindPITCH:
+      return "pitch := " + x
+    case cpu.KindSTORE:
+      return "save " + x
+    case cpu.KindREAD:
+      return "load " + x
+    case cpu.KindSTORER:
+      return "saveflags " + x
+    case cpu.KindREADR:
+      return "loadflags " + x
+  }
+  return fmt.Sprintf("0x%02X 0x%02X", ins.Opcode >> 8, ins.Opcode & 0xFF)
+}
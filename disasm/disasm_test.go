@@ -0,0 +1,95 @@
+package disasm
+
+import (
+  "strings"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+var program = []uint8{
+  0xa2, 0x0e, /* 200: LD I, 0x20E */
+  0x22, 0x0a, /* 202: CALL 0x20A */
+  0x30, 0x01, /* 204: SE V0, 1 */
+  0x12, 0x02, /* 206: JP 0x202 */
+  0x00, 0xfd, /* 208: EXIT */
+  0xd0, 0x02, /* 20A: DRW V0, V0, 2 */
+  0x00, 0xee, /* 20C: RET */
+  0x81, 0xff, /* 20E: sprite */
+  0x12, 0x34, /* 210: unreachable */
+}
+
+func TestSeparation(t *testing.T) {
+  l := Disassemble(program, cpu.PlatformSCHIP)
+  for addr := uint16(0x200); addr < 0x212; addr++ {
+    want := addr < 0x20e
+    if l.IsCode(addr) != want {
+      t.Errorf("Incorrect classification of 0x%03X. Got code %v, wanted %v", addr, l.IsCode(addr), want)
+    }
+  }
+  labels := l.Labels()
+  for addr, want := range map[uint16]string{0x200: "main", 0x202: "label_0202", 0x20a: "sub_020a", 0x20e: "data_020e"} {
+    if labels[addr] != want {
+      t.Errorf("Incorrect label at 0x%03X. Got %q, wanted %q", addr, labels[addr], want)
+    }
+  }
+
+  // EXIT is only SUPER-CHIP, so on CHIP-8 the skipped-to code is data
+  if l := Disassemble(program, cpu.PlatformCHIP8); l.IsCode(0x208) {
+    t.Errorf("00FD should not be code on CHIP-8")
+  }
+}
+
+func TestWrite(t *testing.T) {
+  var out strings.Builder
+  if err := Disassemble(program, cpu.PlatformSCHIP).Write(&out); err != nil {
+    t.Fatal(err)
+  }
+  for _, want := range []string{
+    ": main\n  i := data_020e\n",
+    ": label_0202\n  sub_020a\n  if v0 != 0x01 then\n  jump label_0202\n  exit\n",
+    ": sub_020a\n  sprite v0 v0 2\n  return\n",
+    ": data_020e\n  0x81  # #......#\n  0xFF  # ########\n",
+  } {
+    if !strings.Contains(out.String(), want) {
+      t.Errorf("Listing is missing %q:\n%v", want, out.String())
+    }
+  }
+}
+
+func TestOddAlignment(t *testing.T) {
+  rom := []uint8{
+    0x12, 0x03, /* 200: JP 0x203 */
+    0x00,       /* 202: padding */
+    0x60, 0x05, /* 203: LD V0, 5 */
+    0x12, 0x03, /* 205: JP 0x203 */
+  }
+  l := Disassemble(rom, cpu.PlatformCHIP8)
+  if l.IsCode(0x202) || !l.IsCode(0x203) || !l.IsCode(0x206) {
+    t.Errorf("Odd aligned code was not found")
+  }
+  var out strings.Builder
+  l.Write(&out)
+  if !strings.Contains(out.String(), "jump label_0203\n  0x00\n\n: label_0203\n") {
+    t.Errorf("Incorrect listing:\n%v", out.String())
+  }
+}
+
+func TestXochipSkip(t *testing.T) {
+  rom := []uint8{
+    0x30, 0x00, /* 200: SE V0, 0 */
+    0xf0, 0x00, /* 202: LD I, long 0x0208 */
+    0x02, 0x08,
+    0x00, 0xfd, /* 206: EXIT */
+    0xff,       /* 208: data */
+  }
+  l := Disassemble(rom, cpu.PlatformXOCHIP)
+  if !l.IsCode(0x205) || !l.IsCode(0x206) || l.IsCode(0x208) {
+    t.Errorf("F000 NNNN was not skipped as one instruction")
+  }
+  var out strings.Builder
+  l.Write(&out)
+  if !strings.Contains(out.String(), "i := long data_0208") {
+    t.Errorf("Incorrect long load:\n%v", out.String())
+  }
+}
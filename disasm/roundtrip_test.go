@@ -0,0 +1,102 @@
+package disasm
+
+import (
+  "bytes"
+  "math/rand/v2"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "cryp-8/asm"
+  "cryp-8/cpu"
+)
+
+var platforms = []cpu.Platform{cpu.PlatformCHIP8, cpu.PlatformSCHIP, cpu.PlatformXOCHIP}
+
+// roundTrip disassembles rom, assembles the listing and checks that the
+// same bytes come back.
+func roundTrip(t *testing.T, name string, rom []byte, platform cpu.Platform) {
+  t.Helper()
+  var src strings.Builder
+  if err := Disassemble(rom, platform).Write(&src); err != nil {
+    t.Fatal(err)
+  }
+  p, err := asm.Assemble(name + ".8o", []byte(src.String()))
+  if err != nil {
+    t.Errorf("%v on %v does not assemble: %v\n%v", name, platform, err, src.String())
+    return
+  }
+  if !bytes.Equal(p.ROM, rom) {
+    j := 0
+    for j < len(rom) && j < len(p.ROM) && rom[j] == p.ROM[j] {
+      j++
+    }
+    t.Errorf("%v on %v does not round trip: first difference at 0x%03X, %d bytes instead of %d\n%v",
+      name, platform, start + j, len(p.ROM), len(rom), src.String())
+  }
+}
+
+// TestRoundTripROMs round trips every ROM in the test directories on every
+// platform. testdata has the Octo sources of its ROMs.
+func TestRoundTripROMs(t *testing.T) {
+  var paths []string
+  for _, pattern := range []string{"testdata/*.ch8", "../headless/testdata/roms/*.ch8", "../*.ch8"} {
+    matches, _ := filepath.Glob(pattern)
+    paths = append(paths, matches...)
+  }
+  if len(paths) == 0 {
+    t.Fatal("No ROMs found")
+  }
+  for _, path := range paths {
+    rom, err := os.ReadFile(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, p := range platforms {
+      roundTrip(t, filepath.Base(path), rom, p)
+    }
+  }
+}
+
+// TestSources assembles each Octo source in testdata and checks it gives
+// the ROM of the same name, so the two can't drift apart.
+func TestSources(t *testing.T) {
+  sources, _ := filepath.Glob("testdata/*.8o")
+  if len(sources) == 0 {
+    t.Fatal("No sources found")
+  }
+  for _, path := range sources {
+    src, err := os.ReadFile(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    want, err := os.ReadFile(strings.TrimSuffix(path, ".8o") + ".ch8")
+    if err != nil {
+      t.Fatal(err)
+    }
+    p, err := asm.Assemble(filepath.Base(path), src)
+    if err != nil {
+      t.Errorf("%v does not assemble: %v", path, err)
+      continue
+    }
+    if !bytes.Equal(p.ROM, want) {
+      t.Errorf("%v does not assemble to its ROM. Got % X, wanted % X", path, p.ROM, want)
+    }
+  }
+}
+
+// TestRoundTripRandom round trips random bytes, which are full of odd
+// jumps, overlapping instructions and unknown opcodes.
+func TestRoundTripRandom(t *testing.T) {
+  r := rand.New(rand.NewPCG(1, 2))
+  for n := 0; n < 500; n++ {
+    rom := make([]byte, r.IntN(512))
+    for j := range rom {
+      rom[j] = byte(r.Uint32())
+    }
+    for _, p := range platforms {
+      roundTrip(t, "random", rom, p)
+    }
+  }
+}
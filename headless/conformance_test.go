@@ -0,0 +1,105 @@
+package headless
+
+import (
+  "bytes"
+  "flag"
+  "image"
+  "image/png"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+var update = flag.Bool("update", false, "rewrite the conformance golden images")
+
+// conformance lists the ROMs in testdata/roms, each run for a fixed
+// number of frames and compared against testdata/golden/<name>.png. See
+// testdata/README.md for where the ROMs come from.
+var conformance = []struct {
+  name     string
+  platform cpu.Platform
+  quirks   cpu.Quirks
+  frames   int
+  script   Script
+}{
+  {"cryp8-flags", cpu.PlatformCHIP8, cpu.Quirks{}, 10, nil},
+}
+
+// engines are the ways the CPU can execute. Each conformance ROM runs on
+// all of them against the same golden image, so they can't drift apart.
+var engines = []struct {
+  name string
+  opts []cpu.Option
+}{
+  {"interpreter", nil},
+  {"blocks", []cpu.Option{cpu.WithBlockCache()}},
+}
+
+func TestConformance(t *testing.T) {
+  for _, test := range conformance {
+    for _, engine := range engines {
+      t.Run(test.name + "/" + engine.name, func(t *testing.T) {
+        rom, err := os.ReadFile(filepath.Join("testdata", "roms", test.name + ".ch8"))
+        if os.IsNotExist(err) {
+          t.Fatalf("%v.ch8 is missing from testdata/roms; see testdata/README.md", test.name)
+        }
+        if err != nil {
+          t.Fatal(err)
+        }
+
+        opts := append([]cpu.Option{cpu.WithPlatform(test.platform), cpu.WithQuirks(test.quirks), cpu.WithSeed(1)}, engine.opts...)
+        c := cpu.NewCPU(opts...)
+        c.LoadRom(rom)
+        if err := Run(&c, test.frames, 1000, test.script); err != nil {
+          t.Fatal(err)
+        }
+        got := Image(&c, Palette, 1)
+
+        golden := filepath.Join("testdata", "golden", test.name + ".png")
+        if *update {
+          var buf bytes.Buffer
+          if err := png.Encode(&buf, got); err != nil {
+            t.Fatal(err)
+          }
+          if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+            t.Fatal(err)
+          }
+          return
+        }
+        f, err := os.Open(golden)
+        if err != nil {
+          t.Fatalf("%v (run go test -update to create it)", err)
+        }
+        defer f.Close()
+        want, err := png.Decode(f)
+        if err != nil {
+          t.Fatal(err)
+        }
+        if x, y, ok := sameImage(got, want); !ok {
+          t.Errorf("Display differs from %v, first at %v,%v", golden, x, y)
+        }
+      })
+    }
+  }
+}
+
+// sameImage compares two images pixel by pixel and returns the first
+// pixel that differs.
+func sameImage(a, b image.Image) (int, int, bool) {
+  if a.Bounds() != b.Bounds() {
+    return 0, 0, false
+  }
+  r := a.Bounds()
+  for y := r.Min.Y; y < r.Max.Y; y++ {
+    for x := r.Min.X; x < r.Max.X; x++ {
+      r1, g1, b1, a1 := a.At(x, y).RGBA()
+      r2, g2, b2, a2 := b.At(x, y).RGBA()
+      if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+        return x, y, false
+      }
+    }
+  }
+  return 0, 0, true
+}
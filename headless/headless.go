@@ -0,0 +1,138 @@
+// Package headless runs ROMs without a window and renders the display to
+// image files, for CI machines and tests.
+package headless
+
+import (
+  "bufio"
+  "fmt"
+  "image"
+  "image/color"
+  "image/png"
+  "io"
+  "strconv"
+  "strings"
+
+  "cryp-8/cpu"
+)
+
+// Event presses or releases a key before the given frame runs.
+type Event struct {
+  Frame int
+  Key   uint8
+  Down  bool
+}
+
+// Script is a list of key events in frame order.
+type Script []Event
+
+// ParseScript reads a script of one event per line:
+//
+//   <frame> down|up <key>
+//
+// where key is a hex digit. Blank lines and lines starting with # are
+// ignored.
+func ParseScript(r io.Reader) (Script, error) {
+  var script Script
+  s := bufio.NewScanner(r)
+  for line := 1; s.Scan(); line++ {
+    text := strings.TrimSpace(s.Text())
+    if text == "" || strings.HasPrefix(text, "#") {
+      continue
+    }
+    fields := strings.Fields(text)
+    if len(fields) != 3 {
+      return nil, fmt.Errorf("headless: script line %d: want <frame> down|up <key>", line)
+    }
+    frame, err := strconv.Atoi(fields[0])
+    if err != nil || frame < 0 {
+      return nil, fmt.Errorf("headless: script line %d: bad frame %q", line, fields[0])
+    }
+    if len(script) > 0 && frame < script[len(script) - 1].Frame {
+      return nil, fmt.Errorf("headless: script line %d: frames must not go backwards", line)
+    }
+    var down bool
+    switch fields[1] {
+      case "down":
+        down = true
+      case "up":
+      default:
+        return nil, fmt.Errorf("headless: script line %d: bad action %q", line, fields[1])
+    }
+    key, err := strconv.ParseUint(fields[2], 16, 4)
+    if err != nil {
+      return nil, fmt.Errorf("headless: script line %d: bad key %q", line, fields[2])
+    }
+    script = append(script, Event{frame, uint8(key), down})
+  }
+  return script, s.Err()
+}
+
+// Run runs frames frames of cycles instructions each, applying the
+// script's key events at the start of their frame.
+func Run(c *cpu.CPU, frames, cycles int, script Script) error {
+  for frame := 0; frame < frames; frame++ {
+    for len(script) > 0 && script[0].Frame <= frame {
+      if script[0].Down {
+        c.KeyDown(script[0].Key)
+      } else {
+        c.KeyUp(script[0].Key)
+      }
+      script = script[1:]
+    }
+    if err := c.RunFrame(cycles); err != nil {
+      return fmt.Errorf("headless: frame %d: %w", frame, err)
+    }
+  }
+  return nil
+}
+
+// Palette colors the four values cpu.Colors returns: background, plane 0,
+// plane 1 and both planes.
+var Palette = color.Palette{
+  color.RGBA{0x00, 0x00, 0x00, 0xff},
+  color.RGBA{0xff, 0xff, 0xff, 0xff},
+  color.RGBA{0xaa, 0xaa, 0xaa, 0xff},
+  color.RGBA{0x55, 0x55, 0x55, 0xff},
+}
+
+// Image renders the display with every pixel scaled to a scale by scale
+// square.
+func Image(c *cpu.CPU, palette color.Palette, scale int) *image.Paletted {
+  if scale < 1 {
+    scale = 1
+  }
+  w, h := c.Width(), c.Height()
+  img := image.NewPaletted(image.Rect(0, 0, w*scale, h*scale), palette)
+  colors := c.Colors(nil)
+  for y := 0; y < h*scale; y++ {
+    for x := 0; x < w*scale; x++ {
+      img.Pix[y*img.Stride + x] = colors[(y/scale)*w + x/scale]
+    }
+  }
+  return img
+}
+
+// WritePNG writes the display as a PNG using Palette.
+func WritePNG(w io.Writer, c *cpu.CPU, scale int) error {
+  return png.Encode(w, Image(c, Palette, scale))
+}
+
+// WritePBM writes the display as a binary PBM, with lit pixels (in any
+// plane) as 1 bits.
+func WritePBM(w io.Writer, c *cpu.CPU) error {
+  width, height := c.Width(), c.Height()
+  bw := bufio.NewWriter(w)
+  fmt.Fprintf(bw, "P4\n%d %d\n", width, height)
+  colors := c.Colors(nil)
+  row := make([]byte, (width + 7)/8)
+  for y := 0; y < height; y++ {
+    clear(row)
+    for x := 0; x < width; x++ {
+      if colors[y*width + x] != 0 {
+        row[x/8] |= 0x80 >> (x % 8)
+      }
+    }
+    bw.Write(row)
+  }
+  return bw.Flush()
+}
@@ -0,0 +1,81 @@
+package headless
+
+import (
+  "bytes"
+  "image/png"
+  "strings"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+// drawKey waits for a key and draws its font digit at 0,0.
+var drawKey = []uint8{
+  0xf0, 0x0a, /* LD V0, K */
+  0xf0, 0x29, /* LD F, V0 */
+  0xd1, 0x15, /* DRW V1, V1, 5 */
+  0x12, 0x06, /* JP 0x206 */
+}
+
+func TestParseScript(t *testing.T) {
+  script, err := ParseScript(strings.NewReader("# press 5\n2 down 5\n\n3 up a\n"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := Script{{2, 0x5, true}, {3, 0xa, false}}
+  if len(script) != len(want) || script[0] != want[0] || script[1] != want[1] {
+    t.Errorf("Incorrect script. Got %v, wanted %v", script, want)
+  }
+
+  for _, bad := range []string{"1 down", "x down 1", "1 push 1", "1 down g", "2 down 1\n1 up 1"} {
+    if _, err := ParseScript(strings.NewReader(bad)); err == nil {
+      t.Errorf("Expected an error for %q", bad)
+    }
+  }
+}
+
+func TestRunPNG(t *testing.T) {
+  c := cpu.NewCPU()
+  c.LoadRom(drawKey)
+  if err := Run(&c, 5, 10, Script{{1, 0x1, true}, {2, 0x1, false}}); err != nil {
+    t.Fatal(err)
+  }
+
+  var buf bytes.Buffer
+  if err := WritePNG(&buf, &c, 2); err != nil {
+    t.Fatal(err)
+  }
+  img, err := png.Decode(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if b := img.Bounds(); b.Dx() != 128 || b.Dy() != 64 {
+    t.Fatalf("Incorrect image size %v", b)
+  }
+  // the font's "1" is 0x20 0x60 0x20 0x20 0x70
+  for _, p := range []struct{ x, y int; lit bool }{{4, 0, true}, {5, 1, true}, {0, 0, false}, {2, 2, true}, {2, 8, true}, {6, 8, true}} {
+    r, _, _, _ := img.At(p.x, p.y).RGBA()
+    if (r != 0) != p.lit {
+      t.Errorf("Incorrect pixel at %v,%v. Got %v, wanted %v", p.x, p.y, r != 0, p.lit)
+    }
+  }
+}
+
+func TestWritePBM(t *testing.T) {
+  c := cpu.NewCPU()
+  c.LoadRom(drawKey)
+  Run(&c, 4, 10, Script{{1, 0x0, true}, {2, 0x0, false}})
+
+  var buf bytes.Buffer
+  if err := WritePBM(&buf, &c); err != nil {
+    t.Fatal(err)
+  }
+  header := "P4\n64 32\n"
+  if !strings.HasPrefix(buf.String(), header) || buf.Len() != len(header) + 8*32 {
+    t.Fatalf("Incorrect PBM of %v bytes", buf.Len())
+  }
+  // the font's "0" starts with 0xF0
+  if got := buf.Bytes()[len(header)]; got != 0xf0 {
+    t.Errorf("Incorrect first row. Got %#x, wanted 0xf0", got)
+  }
+}
@@ -1,361 +1,396 @@
 package main
 
 import (
-
-  _ "image/png"
+  "bytes"
+  "cryp-8/asm"
+  "cryp-8/cartridge"
   "cryp-8/cpu"
+  "cryp-8/dap"
+  "cryp-8/debugger"
+  "cryp-8/disasm"
+  "cryp-8/headless"
+  "cryp-8/movie"
+  "cryp-8/romdb"
+  "errors"
+  "flag"
   "fmt"
-  "time"
+  "image/color"
+  "image/png"
+  "io"
   "log"
-  "strings"
-
-  "math/rand"
+  "log/slog"
+  "net"
   "os"
-  "runtime"
-  "github.com/go-gl/gl/v2.1/gl"
-  "github.com/go-gl/glfw/v3.2/glfw"
+  "os/signal"
+  "path/filepath"
+  "strings"
 )
 
-type app struct {
-  cpu *cpu.CPU
+const usage = `usage: cryp-8 [command] [flags] rom
+
+commands:
+  run       play the ROM in a window (the default)
+  headless  run the ROM without a window and save a screenshot
+  debug     step through the ROM in an interactive debugger
+  dap       serve the Debug Adapter Protocol for editors (takes no rom)
+  disasm    disassemble the ROM to Octo source
+  asm       assemble Octo source into a ROM (takes a .8o file)
+
+Run cryp-8 <command> -h for the flags of a command.
+`
+
+var errUsage = errors.New("bad usage")
+
+// commands are the subcommands by name. Any other first argument is a ROM
+// for run, even one without an extension.
+var commands = map[string]func(args []string) error{
+  "run":      runWindow,
+  "headless": runHeadless,
+  "debug":    runDebugger,
+  "dap":      runDAP,
+  "disasm":   runDisasm,
+  "asm":      runAsm,
 }
 
-const (
-  width  = 512
-  height = 256
-
-  vertexShaderSource = `
-    #version 410
-    in vec3 vp;
-    void main() {
-      gl_Position = vec4(vp, 1.0);
-    }
-  ` + "\x00"
-
-  fragmentShaderSource = `
-    #version 410
-    out vec4 frag_colour;
-    void main() {
-      frag_colour = vec4(1, 1, 1, 1.0);
+func main() {
+  run, args := runWindow, os.Args[1:]
+  if len(args) > 0 {
+    if cmd, ok := commands[args[0]]; ok {
+      run, args = cmd, args[1:]
     }
-  ` + "\x00"
-
-  rows    = 64
-  columns = 32
-  threshold = 0.15
-  fps = 60
-)
-
-var (
-  square = []float32{
-    -0.5, 0.5, 0,
-    -0.5, -0.5, 0,
-    0.5, -0.5, 0,
-
-    -0.5, 0.5, 0,
-    0.5, 0.5, 0,
-    0.5, -0.5, 0,
   }
-  shouldRun = true
-
-)
-
-type cell struct {
-    drawable uint32
-
-
-    alive     bool
-    aliveNext bool
+  err := run(args)
+  if err == errUsage {
+    fmt.Fprint(os.Stderr, usage)
+    os.Exit(2)
+  }
+  if err != nil {
+    fmt.Fprintln(os.Stderr, "cryp-8:", err)
+    os.Exit(1)
+  }
+}
 
-    x int
-    y int
+// machineFlags are the flags every command that runs a ROM shares.
+type machineFlags struct {
+  platform string
+  quirks   string
+  seed     uint64
+  cycles   int
+  blocks   bool
 }
 
+func (m *machineFlags) register(fs *flag.FlagSet) {
+  fs.StringVar(&m.platform, "platform", cpu.DefaultPlatform.String(), "instruction set: chip8, schip or xochip")
+  fs.StringVar(&m.quirks, "quirks", "", "quirks preset: vip, schip-legacy, schip-modern or xochip; the platform's by default")
+  fs.Uint64Var(&m.seed, "seed", 0, "random seed, 0 to seed from the clock")
+  fs.IntVar(&m.cycles, "cycles", 10, "instructions per frame")
+  fs.BoolVar(&m.blocks, "blocks", true, "run through the block cache instead of decoding every instruction")
+}
 
-func (h *app) onKey(w *glfw.Window, key glfw.Key, scancode int,
-  action glfw.Action, mods glfw.ModifierKey) {
-  if action != glfw.Press {
-    return  
-  }
-  if key == glfw.KeyEscape {
-    w.SetShouldClose(true)
-  }
-  if key == glfw.KeySpace {
-    shouldRun = true
-  }
-  switch key {
-    case glfw.Key0:
-      h.cpu.SetKey(0)
-    case glfw.Key1:
-      h.cpu.SetKey(1)
-    case glfw.Key2:
-      h.cpu.SetKey(2)
-    case glfw.Key3:
-      h.cpu.SetKey(3)
-    case glfw.Key4:
-      h.cpu.SetKey(4)
-    case glfw.Key5:
-      h.cpu.SetKey(5)
-    case glfw.Key6:
-      h.cpu.SetKey(6)
-    case glfw.Key7:
-      h.cpu.SetKey(7)
-    case glfw.Key8:
-      h.cpu.SetKey(8)
-    case glfw.Key9:
-      h.cpu.SetKey(9)
-    case glfw.KeyA:
-      h.cpu.SetKey(0xa)
-    case glfw.KeyB:
-      h.cpu.SetKey(0xb)
-    case glfw.KeyC:
-      h.cpu.SetKey(0xc)
-    case glfw.KeyD:
-      h.cpu.SetKey(0xd)
-    case glfw.KeyE:
-      h.cpu.SetKey(0xe)
-    case glfw.KeyF:
-      h.cpu.SetKey(0xf)
+func (m *machineFlags) options() ([]cpu.Option, error) {
+  p, ok := cpu.ParsePlatform(m.platform)
+  if !ok {
+    return nil, fmt.Errorf("unknown platform %q", m.platform)
+  }
+  q := cpu.QuirksFor(p)
+  if m.quirks != "" {
+    if q, ok = cpu.QuirksPreset(m.quirks); !ok {
+      return nil, fmt.Errorf("unknown quirks preset %q", m.quirks)
+    }
+  }
+  opts := []cpu.Option{cpu.WithPlatform(p), cpu.WithQuirks(q)}
+  if m.seed != 0 {
+    opts = append(opts, cpu.WithSeed(m.seed))
   }
+  return append(opts, m.engine()...), nil
 }
 
-func main() {
+// engine is the options that choose how instructions are executed, which
+// apply even when a movie supplies the rest.
+func (m *machineFlags) engine() []cpu.Option {
+  if m.blocks {
+    return []cpu.Option{cpu.WithBlockCache()}
+  }
+  return nil
+}
 
-  runtime.LockOSThread()
+// program is a ROM and the settings to run it with.
+type program struct {
+  rom     []byte
+  opts    []cpu.Option
+  cycles  int
+  palette color.Palette
+  // title and keys come from the ROM database; keys maps game inputs
+  // such as up to keypad keys.
+  title string
+  keys  map[string]uint8
+}
 
-  window := initGlfw()
-  defer glfw.Terminate()
-  program := initOpenGL()
+// load reads the ROM at path and configures it from the flags. A .gif is
+// an Octo cartridge, whose program is assembled; any other ROM is looked
+// up in the ROM database. The platform, quirks and tick rate they give are
+// used except where flags on fs were given, and so are their colors. An
+// unknown ROM runs with the flags alone.
+func (m *machineFlags) load(fs *flag.FlagSet, path string) (*program, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  p := &program{rom: data, palette: headless.Palette}
+  var platform cpu.Platform
+  var quirks cpu.Quirks
+  var tickrate int
+  known := true
+  if strings.EqualFold(filepath.Ext(path), ".gif") {
+    c, err := cartridge.Decode(bytes.NewReader(data))
+    if err != nil {
+      return nil, err
+    }
+    if p.rom, err = c.ROM(path); err != nil {
+      return nil, err
+    }
+    platform, quirks, tickrate = c.Options.Platform(), c.Options.Quirks(), c.Options.TickRate
+    p.palette = c.Options.Palette()
+  } else if game, ok := romdb.Default().Lookup(data); ok {
+    slog.Debug("identified ROM", "path", path, "title", game.Program.Title, "platform", game.PlatformID)
+    platform, quirks, tickrate = game.Platform, game.Quirks, game.Tickrate
+    p.title, p.keys = game.Program.Title, game.Keys
+    if game.Palette != nil {
+      p.palette = game.Palette
+    }
+  } else {
+    slog.Warn("unknown ROM, using defaults", "path", path, "sha1", romdb.Hash(data))
+    known = false
+  }
 
-  fmt.Println("Welcome to cryp-8, the only chip-8 emulator in existence.")
-  cpu := cpu.NewCPU()
-  h := app{&cpu}
-  window.SetKeyCallback(h.onKey)
+  set := map[string]bool{}
+  fs.Visit(func(f *flag.Flag) {
+    set[f.Name] = true
+  })
+  if known && !set["platform"] {
+    m.platform = platform.String()
+  }
+  if known && !set["cycles"] && tickrate > 0 {
+    m.cycles = tickrate
+  }
+  if p.opts, err = m.options(); err != nil {
+    return nil, err
+  }
+  if known && !set["quirks"] {
+    p.opts = append(p.opts, cpu.WithQuirks(quirks))
+  }
+  p.cycles = m.cycles
+  return p, nil
+}
 
-  f, err := os.Open("./connect4.ch8")
+// runHeadless is the headless command: it runs a ROM for a number of
+// frames, optionally with scripted input or a movie, and saves the display.
+func runHeadless(args []string) error {
+  fs := flag.NewFlagSet("headless", flag.ExitOnError)
+  var machine machineFlags
+  machine.register(fs)
+  frames := fs.Int("frames", 60, "number of frames to run")
+  keys := fs.String("keys", "", "key script: lines of <frame> down|up <key>")
+  moviePath := fs.String("movie", "", "play back a movie instead of a key script")
+  out := fs.String("o", "out.png", "screenshot path, PNG or .pbm")
+  scale := fs.Int("scale", 1, "PNG pixels per CHIP-8 pixel")
+  fs.Parse(args)
+  if fs.NArg() != 1 {
+    return errUsage
+  }
 
-  b1 := make([]byte, 4096-0x200)
-  _, err = f.Read(b1)
+  p, err := machine.load(fs, fs.Arg(0))
   if err != nil {
-    panic(err)
+    return err
   }
-  cpu.LoadRom(b1)
-  var iteration_times [100]float64
-    cells := makeCells()
 
-  for i := 0; !window.ShouldClose(); i %= 100 {
-    t := time.Now()
-    if shouldRun {
-      cpu.RunCycle()
-      // shouldRun = false
+  if *moviePath != "" {
+    f, err := os.Open(*moviePath)
+    if err != nil {
+      return err
     }
-    glfw.PollEvents()
-
-    if cpu.RefreshScreen {        
-      for x := range cells {
-        for y, c := range cells[x] {
-          c.alive = cpu.Display()[64*(31 - y) + (x)]
-        }
-      }
-      draw(cells, window, program)
-      draw_(&cpu)
-      cpu.RefreshScreen = false
+    m, err := movie.Read(f)
+    f.Close()
+    if err != nil {
+      return err
     }
+    c := cpu.NewCPU(append(m.Options(), machine.engine()...)...)
+    c.LoadRom(p.rom)
+    if err := movie.Play(&c, m); err != nil {
+      return err
+    }
+    return screenshot(&c, *out, *scale, p.palette)
+  }
 
-    if i == 99 {
-      // fmt.Printf("cycles per second: %v\n", calcCPS(iteration_times[:]))
+  var script headless.Script
+  if *keys != "" {
+    f, err := os.Open(*keys)
+    if err != nil {
+      return err
+    }
+    script, err = headless.ParseScript(f)
+    f.Close()
+    if err != nil {
+      return err
     }
-    time.Sleep(time.Second/time.Duration(fps) - time.Since(t))
-    iteration_times[i] = time.Since(t).Seconds()
-    i++
   }
+  c := cpu.NewCPU(p.opts...)
+  c.LoadRom(p.rom)
+  if err := headless.Run(&c, *frames, p.cycles, script); err != nil {
+    return err
+  }
+  return screenshot(&c, *out, *scale, p.palette)
 }
 
-
-func draw_(cpu *cpu.CPU) {
-  f, err := os.Create("./out.img")
-  defer f.Close()
+// screenshot saves the display to path, as a PBM if the name ends in
+// .pbm and otherwise as a PNG in the given colors.
+func screenshot(c *cpu.CPU, path string, scale int, palette color.Palette) error {
+  f, err := os.Create(path)
   if err != nil {
-    panic(err)
-  }
-  out := []byte{}
-  // fmt.Println("len", len(cpu.Display()))
-  for _, val := range cpu.Display() {
-    if val {
-      out = append(out, byte(255))
-    } else {
-      out = append(out, byte(0))
-    }
+    return err
   }
-  f.Write(out)
+  if strings.EqualFold(filepath.Ext(path), ".pbm") {
+    err = headless.WritePBM(f, c)
+  } else {
+    err = png.Encode(f, headless.Image(c, palette, scale))
+  }
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  return err
 }
 
-func calcCPS(x []float64) float64 {
-  var total float64 = 0
-  for _, value:= range x {
-    total += value
+// runDebugger is the debug command: a REPL for stepping through a ROM.
+func runDebugger(args []string) error {
+  fs := flag.NewFlagSet("debug", flag.ExitOnError)
+  var machine machineFlags
+  machine.register(fs)
+  fs.Parse(args)
+  if fs.NArg() != 1 {
+    return errUsage
+  }
+  p, err := machine.load(fs, fs.Arg(0))
+  if err != nil {
+    return err
   }
-  return float64(len(x))/total
+  c := cpu.NewCPU(p.opts...)
+  c.LoadRom(p.rom)
+  s := debugger.NewSession(&c, p.cycles)
+
+  // Ctrl-C stops a running continue instead of quitting
+  interrupts := make(chan os.Signal, 1)
+  signal.Notify(interrupts, os.Interrupt)
+  defer signal.Stop(interrupts)
+  go func() {
+    for range interrupts {
+      s.Interrupt()
+    }
+  }()
+  return debugger.REPL(s, os.Stdin, os.Stdout)
 }
 
-// initGlfw initializes glfw and returns a Window to use.
-func initGlfw() *glfw.Window {
-  if err := glfw.Init(); err != nil {
-    panic(err)
-  }
-  glfw.WindowHint(glfw.Resizable, glfw.False)
-  glfw.WindowHint(glfw.ContextVersionMajor, 4)
-  glfw.WindowHint(glfw.ContextVersionMinor, 1)
-  glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-  glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
-
-  window, err := glfw.CreateWindow(width, height, "Conway's Game of Life", nil, nil)
+// runDAP is the dap command. It speaks the Debug Adapter Protocol on stdin
+// and stdout, or on a TCP address with -listen; the ROM comes from the
+// client's launch request.
+func runDAP(args []string) error {
+  fs := flag.NewFlagSet("dap", flag.ExitOnError)
+  listen := fs.String("listen", "", "serve on this TCP address, such as 127.0.0.1:4711, instead of stdio")
+  fs.Parse(args)
+  if fs.NArg() != 0 {
+    return errUsage
+  }
+  if *listen == "" {
+    return dap.Serve(struct {
+      io.Reader
+      io.Writer
+    }{os.Stdin, os.Stdout})
+  }
+  l, err := net.Listen("tcp", *listen)
   if err != nil {
-    panic(err)
+    return err
+  }
+  log.Println("dap: listening on", l.Addr())
+  for {
+    conn, err := l.Accept()
+    if err != nil {
+      return err
+    }
+    go func() {
+      defer conn.Close()
+      if err := dap.Serve(conn); err != nil {
+        log.Println("dap:", err)
+      }
+    }()
   }
-  window.MakeContextCurrent()
-
-  return window
 }
 
-// initOpenGL initializes OpenGL and returns an intiialized program.
-func initOpenGL() uint32 {
-  if err := gl.Init(); err != nil {
-    panic(err)
+// runDisasm is the disasm command: it writes the ROM as Octo source.
+func runDisasm(args []string) error {
+  fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+  platform := fs.String("platform", "xochip", "instruction set: chip8, schip or xochip")
+  out := fs.String("o", "", "output path instead of stdout")
+  fs.Parse(args)
+  if fs.NArg() != 1 {
+    return errUsage
   }
-  version := gl.GoStr(gl.GetString(gl.VERSION))
-  log.Println("OpenGL version", version)
-
-  vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+  p, ok := cpu.ParsePlatform(*platform)
+  if !ok {
+    return fmt.Errorf("unknown platform %q", *platform)
+  }
+  rom, err := os.ReadFile(fs.Arg(0))
   if err != nil {
-    panic(err)
+    return err
   }
-
-  fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+  l := disasm.Disassemble(rom, p)
+  if *out == "" {
+    return l.Write(os.Stdout)
+  }
+  f, err := os.Create(*out)
   if err != nil {
-    panic(err)
+    return err
   }
-
-  prog := gl.CreateProgram()
-  gl.AttachShader(prog, vertexShader)
-  gl.AttachShader(prog, fragmentShader)
-  gl.LinkProgram(prog)
-  return prog
-}
-
-// makeVao initializes and returns a vertex array from the points provided.
-func makeVao(points []float32) uint32 {
-  var vbo uint32
-  gl.GenBuffers(1, &vbo)
-  gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
-  gl.BufferData(gl.ARRAY_BUFFER, 4*len(points), gl.Ptr(points), gl.STATIC_DRAW)
-
-  var vao uint32
-  gl.GenVertexArrays(1, &vao)
-  gl.BindVertexArray(vao)
-  gl.EnableVertexAttribArray(0)
-  gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
-  gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 0, nil)
-
-  return vao
-}
-
-func compileShader(source string, shaderType uint32) (uint32, error) {
-  shader := gl.CreateShader(shaderType)
-
-  csources, free := gl.Strs(source)
-  gl.ShaderSource(shader, 1, csources, nil)
-  free()
-  gl.CompileShader(shader)
-
-  var status int32
-  gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
-  if status == gl.FALSE {
-    var logLength int32
-    gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
-
-    log := strings.Repeat("\x00", int(logLength+1))
-    gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
-
-    return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+  if err := l.Write(f); err != nil {
+    f.Close()
+    return err
   }
-
-  return shader, nil
+  return f.Close()
 }
 
-func draw(cells [][]*cell, window *glfw.Window, program uint32) {
-  gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-  gl.UseProgram(program)
-
-  for x := range cells {
-    for _, c := range cells[x] {
-      c.draw()
-    }
+// runAsm is the asm command: it assembles Octo source into a ROM and
+// writes the symbol map next to it, where the dap command looks for it.
+func runAsm(args []string) error {
+  fs := flag.NewFlagSet("asm", flag.ExitOnError)
+  out := fs.String("o", "", "ROM path, the source path with .ch8 by default")
+  sym := fs.Bool("sym", true, "also write the symbol map to <rom>.sym")
+  fs.Parse(args)
+  if fs.NArg() != 1 {
+    return errUsage
   }
-
-  glfw.PollEvents()
-  window.SwapBuffers()
-}
-
-
-func makeCells() [][]*cell {
-    rand.Seed(time.Now().UnixNano())
-
-    cells := make([][]*cell, rows)
-    for x := 0; x < rows; x++ {
-        for y := 0; y < columns; y++ {
-            c := newCell(x, y)
-            
-            c.alive = true
-            c.aliveNext = c.alive
-            
-            cells[x] = append(cells[x], c)
-        }
-    }
-
-  return cells
-}
-
-func newCell(x, y int) *cell {
-  // fmt.Println(x,y)
-  points := make([]float32, len(square), len(square))
-  copy(points, square)
-
-  for i := 0; i < len(points); i++ {
-    var position float32
-    var size float32
-    switch i % 3 {
-    case 0:
-      size = 1.0 / float32(rows)
-      position = float32(x) * size
-      // fmt.Println("x=",position)
-    case 1:
-      size = 1.0 / float32(columns)
-      position = float32(y) * size
-      // fmt.Println("y=",position)
-    default:
-      continue
-    }
-
-    if points[i] < 0 {
-      points[i] = (position * 2) - 1
-    } else {
-      points[i] = ((position + size) * 2) - 1
-    }
+  path := fs.Arg(0)
+  src, err := os.ReadFile(path)
+  if err != nil {
+    return err
   }
-
-  return &cell{
-    drawable: makeVao(points),
-
-    x: x,
-    y: y,
+  p, err := asm.Assemble(path, src)
+  if err != nil {
+    return err
+  }
+  if *out == "" {
+    *out = strings.TrimSuffix(path, filepath.Ext(path)) + ".ch8"
+  }
+  if err := os.WriteFile(*out, p.ROM, 0644); err != nil {
+    return err
   }
+  if !*sym {
+    return nil
+  }
+  f, err := os.Create(*out + ".sym")
+  if err != nil {
+    return err
+  }
+  if err := p.Symbols.Write(f); err != nil {
+    f.Close()
+    return err
+  }
+  return f.Close()
 }
-func (c *cell) draw() {
-    if !c.alive {
-            return
-    }
-
-    gl.BindVertexArray(c.drawable)
-    gl.DrawArrays(gl.TRIANGLES, 0, int32(len(square)/3))
-}
\ No newline at end of file
@@ -0,0 +1,294 @@
+// Package movie records the keypad input of a session so that it can be
+// played back exactly, for bug reports and regression tests.
+package movie
+
+import (
+  "bufio"
+  "crypto/sha1"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+
+  "cryp-8/cpu"
+)
+
+// A movie file is
+//
+//   magic    "CRY8MOVI"
+//   version  uint16
+//   rom      [20]byte, SHA-1 of the ROM
+//   seed     uint64, the CPU's random seed
+//   platform uint8
+//   quirks   uint16, bit n set for the nth quirk in quirkBits
+//   cycles   uint16, instructions per frame
+//
+// followed by one uint16 keypad mask per frame until the end of the file,
+// bit k set while key k is held. All numbers are big-endian.
+const (
+  magic   = "CRY8MOVI"
+  version = 1
+)
+
+type header struct {
+  Magic   [8]byte
+  Version uint16
+}
+
+// config is the rest of the header.
+type config struct {
+  ROM      [sha1.Size]byte
+  Seed     uint64
+  Platform uint8
+  Quirks   uint16
+  Cycles   uint16
+}
+
+// quirkBits gives each quirk its bit in the header. Bits are never reused
+// or reordered; a new quirk takes the next one.
+var quirkBits = []func(q *cpu.Quirks) *bool{
+  func(q *cpu.Quirks) *bool { return &q.ShiftVX },
+  func(q *cpu.Quirks) *bool { return &q.IncrementI },
+  func(q *cpu.Quirks) *bool { return &q.JumpVX },
+  func(q *cpu.Quirks) *bool { return &q.ResetVF },
+  func(q *cpu.Quirks) *bool { return &q.ClipSprites },
+  func(q *cpu.Quirks) *bool { return &q.DisplayWait },
+  func(q *cpu.Quirks) *bool { return &q.HalfScroll },
+  func(q *cpu.Quirks) *bool { return &q.CountCollisions },
+  func(q *cpu.Quirks) *bool { return &q.IncrementIByX },
+}
+
+func quirksMask(q cpu.Quirks) uint16 {
+  var mask uint16
+  for n, field := range quirkBits {
+    if *field(&q) {
+      mask |= 1 << n
+    }
+  }
+  return mask
+}
+
+func maskQuirks(mask uint16) (cpu.Quirks, error) {
+  var q cpu.Quirks
+  for n, field := range quirkBits {
+    *field(&q) = mask & (1 << n) != 0
+  }
+  if mask >> len(quirkBits) != 0 {
+    return q, fmt.Errorf("movie: unknown quirks %#04x", mask)
+  }
+  return q, nil
+}
+
+var (
+  // ErrNotMovie is returned by Read for data that isn't a movie.
+  ErrNotMovie = errors.New("movie: not a movie file")
+  // ErrVersion is returned by Read for any other format version.
+  ErrVersion = errors.New("movie: unsupported movie version")
+  // ErrUnseeded is returned by NewRecorder and NewPlayer for a CPU whose
+  // random numbers can't be reproduced from its seed.
+  ErrUnseeded = errors.New("movie: CPU has its own random source")
+)
+
+// Movie is everything needed to replay a session: the machine
+// configuration it started from and the keypad state of every frame.
+type Movie struct {
+  ROM      [sha1.Size]byte
+  Seed     uint64
+  Platform cpu.Platform
+  Quirks   cpu.Quirks
+  Cycles   int
+  Frames   []uint16
+}
+
+// Options are the CPU options that recreate the machine the movie was
+// recorded on.
+func (m *Movie) Options() []cpu.Option {
+  return []cpu.Option{cpu.WithPlatform(m.Platform), cpu.WithQuirks(m.Quirks), cpu.WithSeed(m.Seed)}
+}
+
+// Write writes the movie to w.
+func (m *Movie) Write(w io.Writer) error {
+  bw := bufio.NewWriter(w)
+  h := header{Version: version}
+  copy(h.Magic[:], magic)
+  binary.Write(bw, binary.BigEndian, h)
+  binary.Write(bw, binary.BigEndian, config{
+    ROM:      m.ROM,
+    Seed:     m.Seed,
+    Platform: uint8(m.Platform),
+    Quirks:   quirksMask(m.Quirks),
+    Cycles:   uint16(m.Cycles),
+  })
+  binary.Write(bw, binary.BigEndian, m.Frames)
+  return bw.Flush()
+}
+
+// Read reads a movie written by Write.
+func Read(r io.Reader) (*Movie, error) {
+  br := bufio.NewReader(r)
+  var h header
+  if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+    return nil, ErrNotMovie
+  }
+  if string(h.Magic[:]) != magic {
+    return nil, ErrNotMovie
+  }
+  if h.Version != version {
+    return nil, ErrVersion
+  }
+  var c config
+  if err := binary.Read(br, binary.BigEndian, &c); err != nil {
+    return nil, ErrNotMovie
+  }
+  quirks, err := maskQuirks(c.Quirks)
+  if err != nil {
+    return nil, err
+  }
+  m := &Movie{
+    ROM:      c.ROM,
+    Seed:     c.Seed,
+    Platform: cpu.Platform(c.Platform),
+    Quirks:   quirks,
+    Cycles:   int(c.Cycles),
+  }
+  for {
+    var mask uint16
+    err := binary.Read(br, binary.BigEndian, &mask)
+    if err == io.EOF {
+      return m, nil
+    }
+    if err != nil {
+      return nil, fmt.Errorf("movie: reading frame %d: %w", len(m.Frames), err)
+    }
+    m.Frames = append(m.Frames, mask)
+  }
+}
+
+// Recorder sits between the frontend's key events and the CPU. Key events
+// are buffered and handed to the CPU at the start of the next frame, which
+// is also when they are recorded, so the CPU only ever sees what the movie
+// will replay.
+type Recorder struct {
+  cpu     *cpu.CPU
+  movie   Movie
+  held    uint16
+  tapped  uint16
+  applied uint16
+}
+
+// NewRecorder starts recording input for c, which should have its ROM
+// loaded and not have run yet. cycles is the number of instructions run
+// per frame. The CPU's random numbers must come from its seed.
+func NewRecorder(c *cpu.CPU, cycles int) (*Recorder, error) {
+  if !c.Seeded() {
+    return nil, ErrUnseeded
+  }
+  return &Recorder{
+    cpu: c,
+    movie: Movie{
+      ROM:      c.ROMHash(),
+      Seed:     c.Seed(),
+      Platform: c.Platform(),
+      Quirks:   c.Quirks(),
+      Cycles:   cycles,
+    },
+  }, nil
+}
+
+// KeyDown records that key k was pressed.
+func (r *Recorder) KeyDown(k uint8) {
+  r.held |= 1 << (k & 0xF)
+  r.tapped |= 1 << (k & 0xF)
+}
+
+// KeyUp records that key k was released. A key pressed and released
+// within one frame is still held for that frame.
+func (r *Recorder) KeyUp(k uint8) {
+  r.held &^= 1 << (k & 0xF)
+}
+
+// BeginFrame passes the keypad state to the CPU and records it. Call it
+// before running each frame.
+func (r *Recorder) BeginFrame() {
+  mask := r.held | r.tapped
+  r.tapped = 0
+  apply(r.cpu, r.applied, mask)
+  r.applied = mask
+  r.movie.Frames = append(r.movie.Frames, mask)
+}
+
+// Movie returns what has been recorded so far.
+func (r *Recorder) Movie() *Movie {
+  m := r.movie
+  m.Frames = append([]uint16(nil), r.movie.Frames...)
+  return &m
+}
+
+// Player feeds a movie's input to a CPU.
+type Player struct {
+  cpu     *cpu.CPU
+  movie   *Movie
+  frame   int
+  applied uint16
+}
+
+// NewPlayer checks that c matches the machine m was recorded on. c should
+// have been created with m.Options and have the ROM loaded.
+func NewPlayer(c *cpu.CPU, m *Movie) (*Player, error) {
+  if c.ROMHash() != m.ROM {
+    return nil, errors.New("movie: recorded with a different ROM")
+  }
+  if !c.Seeded() {
+    return nil, ErrUnseeded
+  }
+  if c.Seed() != m.Seed || c.Platform() != m.Platform || c.Quirks() != m.Quirks {
+    return nil, errors.New("movie: CPU is not configured like the recording")
+  }
+  return &Player{cpu: c, movie: m}, nil
+}
+
+// BeginFrame passes the next frame's keypad state to the CPU. It reports
+// false once the movie has ended.
+func (p *Player) BeginFrame() bool {
+  if p.Done() {
+    return false
+  }
+  mask := p.movie.Frames[p.frame]
+  apply(p.cpu, p.applied, mask)
+  p.applied = mask
+  p.frame++
+  return true
+}
+
+// Done reports whether every frame has been played.
+func (p *Player) Done() bool {
+  return p.frame >= len(p.movie.Frames)
+}
+
+// Play runs the whole movie on c without a frontend.
+func Play(c *cpu.CPU, m *Movie) error {
+  p, err := NewPlayer(c, m)
+  if err != nil {
+    return err
+  }
+  for p.BeginFrame() {
+    if err := c.RunFrame(m.Cycles); err != nil {
+      return fmt.Errorf("movie: frame %d: %w", p.frame - 1, err)
+    }
+  }
+  return nil
+}
+
+// apply presses and releases keys on c to go from keypad state prev to
+// next.
+func apply(c *cpu.CPU, prev, next uint16) {
+  for k := uint8(0); k < 16; k++ {
+    bit := uint16(1) << k
+    switch {
+      case next & bit != 0 && prev & bit == 0:
+        c.KeyDown(k)
+      case next & bit == 0 && prev & bit != 0:
+        c.KeyUp(k)
+    }
+  }
+}
@@ -0,0 +1,152 @@
+package movie
+
+import (
+  "bytes"
+  "math/rand/v2"
+  "reflect"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+var keyRandom = []uint8{
+  0xf1, 0x0a, /* LD V1, K */
+  0xc2, 0xff, /* RND V2, 0xFF */
+  0x83, 0x24, /* ADD V3, V2 */
+  0xe4, 0x9e, /* SKP V4 */
+  0x12, 0x00, /* JP 0x200 */
+  0xa3, 0x00, /* LD I, 0x300 */
+  0xf3, 0x55, /* LD [I], V3 */
+  0x12, 0x00, /* JP 0x200 */
+}
+
+func snapshot(c *cpu.CPU, t *testing.T) []byte {
+  var buf bytes.Buffer
+  if err := c.SaveState(&buf); err != nil {
+    t.Fatal(err)
+  }
+  return buf.Bytes()
+}
+
+func TestRecordAndPlay(t *testing.T) {
+  c := cpu.NewCPU(cpu.WithSeed(42))
+  c.LoadRom(keyRandom)
+  r, err := NewRecorder(&c, 7)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for frame := 0; frame < 40; frame++ {
+    switch frame % 5 {
+      case 0:
+        r.KeyDown(uint8(frame % 16))
+      case 1:
+        r.KeyUp(uint8((frame - 1) % 16))
+      case 3:
+        // pressed and released between two frames
+        r.KeyDown(4)
+        r.KeyUp(4)
+    }
+    r.BeginFrame()
+    if err := c.RunFrame(7); err != nil {
+      t.Fatal(err)
+    }
+  }
+  want := snapshot(&c, t)
+
+  var buf bytes.Buffer
+  if err := r.Movie().Write(&buf); err != nil {
+    t.Fatal(err)
+  }
+  m, err := Read(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(m.Frames) != 40 || m.Seed != 42 || m.Cycles != 7 {
+    t.Fatalf("Incorrect movie header: %v frames, seed %v, %v cycles", len(m.Frames), m.Seed, m.Cycles)
+  }
+  if m.Frames[3] & (1 << 4) == 0 {
+    t.Errorf("Tapped key was not recorded")
+  }
+
+  replay := cpu.NewCPU(m.Options()...)
+  replay.LoadRom(keyRandom)
+  if err := Play(&replay, m); err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(snapshot(&replay, t), want) {
+    t.Errorf("Playback diverged from the recording")
+  }
+}
+
+func TestPlayMismatch(t *testing.T) {
+  c := cpu.NewCPU(cpu.WithSeed(1))
+  c.LoadRom(keyRandom)
+  r, err := NewRecorder(&c, 10)
+  if err != nil {
+    t.Fatal(err)
+  }
+  m := r.Movie()
+
+  other := cpu.NewCPU(cpu.WithSeed(2))
+  other.LoadRom(keyRandom)
+  if _, err := NewPlayer(&other, m); err == nil {
+    t.Errorf("Expected an error for a different seed")
+  }
+
+  other = cpu.NewCPU(m.Options()...)
+  other.LoadRom(keyRandom[:4])
+  if _, err := NewPlayer(&other, m); err == nil {
+    t.Errorf("Expected an error for a different ROM")
+  }
+}
+
+func TestUnseeded(t *testing.T) {
+  c := cpu.NewCPU(cpu.WithRandSource(rand.NewChaCha8([32]byte{})))
+  c.LoadRom(keyRandom)
+  if _, err := NewRecorder(&c, 10); err != ErrUnseeded {
+    t.Errorf("Expected ErrUnseeded recording, got %v", err)
+  }
+  if _, err := NewPlayer(&c, &Movie{ROM: c.ROMHash()}); err != ErrUnseeded {
+    t.Errorf("Expected ErrUnseeded playing, got %v", err)
+  }
+}
+
+func TestQuirks(t *testing.T) {
+  if n := reflect.TypeOf(cpu.Quirks{}).NumField(); n != len(quirkBits) {
+    t.Fatalf("cpu.Quirks has %v fields but quirkBits has %v", n, len(quirkBits))
+  }
+  m := &Movie{Quirks: cpu.QuirksSCHIPLegacy, Cycles: 15}
+  var buf bytes.Buffer
+  m.Write(&buf)
+  header := buf.Bytes()
+  if mask := header[len(header) - 4:len(header) - 2]; !bytes.Equal(mask, []byte{0x00, 0xf5}) {
+    t.Errorf("Incorrect quirks mask % X", mask)
+  }
+  got, err := Read(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got.Quirks != cpu.QuirksSCHIPLegacy {
+    t.Errorf("Incorrect quirks %+v", got.Quirks)
+  }
+
+  header[len(header) - 4] = 0x80
+  if _, err := Read(bytes.NewReader(header)); err == nil {
+    t.Errorf("Expected an error for an unknown quirk")
+  }
+}
+
+func TestReadBad(t *testing.T) {
+  if _, err := Read(bytes.NewReader([]byte("CRY8SAVE and more bytes to fill a header...."))); err != ErrNotMovie {
+    t.Errorf("Expected ErrNotMovie, got %v", err)
+  }
+  var buf bytes.Buffer
+  (&Movie{}).Write(&buf)
+  for _, v := range []byte{0, 2} {
+    data := append([]byte(nil), buf.Bytes()...)
+    data[len(magic) + 1] = v
+    if _, err := Read(bytes.NewReader(data)); err != ErrVersion {
+      t.Errorf("Expected ErrVersion for version %v, got %v", v, err)
+    }
+  }
+}
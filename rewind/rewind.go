@@ -0,0 +1,177 @@
+// Package rewind records periodic snapshots of a CPU so that emulation can
+// be stepped backwards.
+package rewind
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+
+  "cryp-8/cpu"
+)
+
+// Buffer is a ring of CPU snapshots. Only the newest snapshot is kept
+// whole; every older one is stored as a run-length encoded XOR delta
+// against the snapshot that followed it, so frames where little changed
+// cost a few bytes.
+type Buffer struct {
+  depth    int
+  interval int
+  frames   int
+
+  latest []byte
+  deltas [][]byte // ring of deltas, oldest at start
+  start  int
+  count  int
+}
+
+// New makes a Buffer that keeps up to depth snapshots, taking one every
+// interval calls to Capture.
+func New(depth, interval int) *Buffer {
+  if depth < 1 {
+    depth = 1
+  }
+  if interval < 1 {
+    interval = 1
+  }
+  return &Buffer{
+    depth:    depth,
+    interval: interval,
+    deltas:   make([][]byte, depth - 1),
+  }
+}
+
+// Len is the number of snapshots that can be rewound to.
+func (b *Buffer) Len() int {
+  if b.latest == nil {
+    return 0
+  }
+  return b.count + 1
+}
+
+// Reset drops every snapshot.
+func (b *Buffer) Reset() {
+  b.latest = nil
+  b.start, b.count, b.frames = 0, 0, 0
+}
+
+// Capture should be called once per emulated frame. Every interval-th call
+// snapshots c.
+func (b *Buffer) Capture(c *cpu.CPU) error {
+  b.frames++
+  if b.frames < b.interval {
+    return nil
+  }
+  b.frames = 0
+
+  var buf bytes.Buffer
+  if err := c.SaveState(&buf); err != nil {
+    return err
+  }
+  next := buf.Bytes()
+  if b.latest != nil && len(b.deltas) > 0 {
+    if b.count == len(b.deltas) {
+      b.start = (b.start + 1) % len(b.deltas)
+      b.count--
+    }
+    b.deltas[(b.start + b.count) % len(b.deltas)] = encodeDelta(b.latest, next)
+    b.count++
+  }
+  b.latest = next
+  return nil
+}
+
+// Rewind restores c to the newest snapshot and forgets it, so that
+// repeated calls walk backwards in time. It reports false when there is
+// nothing left to rewind to.
+func (b *Buffer) Rewind(c *cpu.CPU) (bool, error) {
+  if b.latest == nil {
+    return false, nil
+  }
+  if err := c.LoadState(bytes.NewReader(b.latest)); err != nil {
+    return false, err
+  }
+  b.frames = 0
+  if b.count == 0 {
+    b.latest = nil
+    return true, nil
+  }
+  b.count--
+  i := (b.start + b.count) % len(b.deltas)
+  prev, err := decodeDelta(b.deltas[i], b.latest)
+  if err != nil {
+    return true, err
+  }
+  b.deltas[i] = nil
+  b.latest = prev
+  return true, nil
+}
+
+var errBadDelta = errors.New("rewind: corrupt delta")
+
+// encodeDelta describes prev in terms of next: prev XOR next, written as
+// pairs of a run of zero bytes and a run of literal bytes, each preceded
+// by its length. A snapshot of a different length is stored whole behind
+// a zero-length marker.
+func encodeDelta(prev, next []byte) []byte {
+  var out []byte
+  if len(prev) != len(next) {
+    out = binary.AppendUvarint(out, 0)
+    return append(out, prev...)
+  }
+  out = binary.AppendUvarint(out, uint64(len(prev)) + 1)
+  for i := 0; i < len(prev); {
+    zeros := i
+    for zeros < len(prev) && prev[zeros] == next[zeros] {
+      zeros++
+    }
+    literal := zeros
+    for literal < len(prev) && prev[literal] != next[literal] {
+      literal++
+    }
+    out = binary.AppendUvarint(out, uint64(zeros - i))
+    out = binary.AppendUvarint(out, uint64(literal - zeros))
+    for j := zeros; j < literal; j++ {
+      out = append(out, prev[j] ^ next[j])
+    }
+    i = literal
+  }
+  return out
+}
+
+func decodeDelta(delta, next []byte) ([]byte, error) {
+  size, n := binary.Uvarint(delta)
+  if n <= 0 {
+    return nil, errBadDelta
+  }
+  delta = delta[n:]
+  if size == 0 {
+    return append([]byte(nil), delta...), nil
+  }
+  if int(size - 1) != len(next) {
+    return nil, errBadDelta
+  }
+  prev := append([]byte(nil), next...)
+  for i := 0; len(delta) > 0; {
+    zeros, n := binary.Uvarint(delta)
+    if n <= 0 {
+      return nil, errBadDelta
+    }
+    delta = delta[n:]
+    literal, n := binary.Uvarint(delta)
+    if n <= 0 || uint64(len(delta) - n) < literal {
+      return nil, errBadDelta
+    }
+    delta = delta[n:]
+    i += int(zeros)
+    if i + int(literal) > len(prev) {
+      return nil, errBadDelta
+    }
+    for j := 0; j < int(literal); j++ {
+      prev[i + j] ^= delta[j]
+    }
+    delta = delta[literal:]
+    i += int(literal)
+  }
+  return prev, nil
+}
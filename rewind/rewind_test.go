@@ -0,0 +1,73 @@
+package rewind
+
+import (
+  "bytes"
+  "testing"
+
+  "cryp-8/cpu"
+)
+
+var counter = []uint8{
+  0x60, 0x00, /* LD V0, 0 */
+  0x70, 0x01, /* ADD V0, 1 */
+  0xa3, 0x00, /* LD I, 0x300 */
+  0xf0, 0x33, /* LD B, V0 */
+  0x12, 0x02, /* JP 0x202 */
+}
+
+func snapshot(c *cpu.CPU, t *testing.T) []byte {
+  var buf bytes.Buffer
+  if err := c.SaveState(&buf); err != nil {
+    t.Fatal(err)
+  }
+  return buf.Bytes()
+}
+
+func TestRewind(t *testing.T) {
+  c := cpu.NewCPU(cpu.WithSeed(1))
+  c.LoadRom(counter)
+  b := New(5, 2)
+
+  var snapshots [][]byte
+  for frame := 1; frame <= 20; frame++ {
+    c.RunFrame(3)
+    b.Capture(&c)
+    if frame % 2 == 0 {
+      snapshots = append(snapshots, snapshot(&c, t))
+    }
+  }
+  if b.Len() != 5 {
+    t.Errorf("Incorrect depth. Got %v, wanted 5", b.Len())
+  }
+
+  for k := len(snapshots) - 1; k >= len(snapshots) - 5; k-- {
+    ok, err := b.Rewind(&c)
+    if !ok || err != nil {
+      t.Fatalf("Rewind failed: %v, %v", ok, err)
+    }
+    if !bytes.Equal(snapshot(&c, t), snapshots[k]) {
+      t.Errorf("Rewind %v restored the wrong state", len(snapshots) - k)
+    }
+  }
+  if ok, _ := b.Rewind(&c); ok {
+    t.Errorf("Expected rewind buffer to be empty")
+  }
+}
+
+func TestDelta(t *testing.T) {
+  next := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+  prev := []byte{1, 2, 9, 4, 5, 6, 0, 0}
+  got, err := decodeDelta(encodeDelta(prev, next), next)
+  if err != nil || !bytes.Equal(got, prev) {
+    t.Errorf("Delta round trip failed: %v, %v", got, err)
+  }
+
+  got, err = decodeDelta(encodeDelta(prev[:3], next), next)
+  if err != nil || !bytes.Equal(got, prev[:3]) {
+    t.Errorf("Delta of different lengths failed: %v, %v", got, err)
+  }
+
+  if len(encodeDelta(next, next)) > 4 {
+    t.Errorf("Identical snapshots should encode to almost nothing")
+  }
+}
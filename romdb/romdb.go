@@ -0,0 +1,243 @@
+// Package romdb identifies ROMs by their SHA-1 and knows the settings they
+// need. It reads the files of the community chip-8-database
+// (https://github.com/chip-8/chip-8-database): sha1-hashes.json,
+// programs.json and platforms.json. A subset of it is built in.
+package romdb
+
+import (
+  "crypto/sha1"
+  "embed"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "image/color"
+  "io/fs"
+  "strconv"
+  "strings"
+  "sync"
+
+  "cryp-8/cpu"
+)
+
+//go:embed data/*.json
+var data embed.FS
+
+// Program is a game or demo, which may have several versions.
+type Program struct {
+  Title       string         `json:"title"`
+  Description string         `json:"description"`
+  Authors     []string       `json:"authors"`
+  Release     string         `json:"release"`
+  ROMs        map[string]ROM `json:"roms"`
+}
+
+// ROM is one version of a program, keyed by its SHA-1.
+type ROM struct {
+  File      string `json:"file"`
+  Tickrate  int    `json:"tickrate"`
+  // Platforms lists the platforms the ROM runs on, best first.
+  Platforms []string `json:"platforms"`
+  // QuirkyPlatforms changes some of the quirks of a platform for this ROM.
+  QuirkyPlatforms map[string]Quirks `json:"quirkyPlatforms"`
+  // Keys maps game inputs, such as up or a, to keypad keys.
+  Keys   map[string]uint8 `json:"keys"`
+  Colors *Colors          `json:"colors"`
+}
+
+// Colors are the colors a ROM is meant to be shown in. Pixels are the
+// background, plane 0, plane 1 and both planes, as #RRGGBB.
+type Colors struct {
+  Pixels  []string `json:"pixels"`
+  Buzzer  string   `json:"buzzer"`
+  Silence string   `json:"silence"`
+}
+
+// Quirks are named as in the database: shift, memoryIncrementByX,
+// memoryLeaveIUnchanged, wrap, jump, vblank and logic.
+type Quirks map[string]bool
+
+// Platform is an entry of platforms.json.
+type Platform struct {
+  ID              string `json:"id"`
+  Name            string `json:"name"`
+  DefaultTickrate int    `json:"defaultTickrate"`
+  Quirks          Quirks `json:"quirks"`
+}
+
+// Database is a loaded database.
+type Database struct {
+  programs  []Program
+  hashes    map[string]int
+  platforms map[string]Platform
+}
+
+// Load reads sha1-hashes.json, programs.json and platforms.json from fsys.
+func Load(fsys fs.FS) (*Database, error) {
+  db := &Database{platforms: map[string]Platform{}}
+  var platforms []Platform
+  for name, v := range map[string]any{
+    "sha1-hashes.json": &db.hashes,
+    "programs.json":    &db.programs,
+    "platforms.json":   &platforms,
+  } {
+    b, err := fs.ReadFile(fsys, name)
+    if err != nil {
+      return nil, err
+    }
+    if err := json.Unmarshal(b, v); err != nil {
+      return nil, fmt.Errorf("romdb: %s: %w", name, err)
+    }
+  }
+  for _, p := range platforms {
+    db.platforms[p.ID] = p
+  }
+  for hash, j := range db.hashes {
+    if j < 0 || j >= len(db.programs) {
+      return nil, fmt.Errorf("romdb: %s refers to program %d of %d", hash, j, len(db.programs))
+    }
+  }
+  return db, nil
+}
+
+// Default returns the built-in database, which is loaded once.
+func Default() *Database {
+  return builtIn()
+}
+
+// builtIn panics if the embedded files are broken, which TestEmbedded
+// catches.
+var builtIn = sync.OnceValue(func() *Database {
+  db, err := loadEmbedded()
+  if err != nil {
+    panic(err)
+  }
+  return db
+})
+
+func loadEmbedded() (*Database, error) {
+  sub, err := fs.Sub(data, "data")
+  if err != nil {
+    return nil, err
+  }
+  return Load(sub)
+}
+
+// superchipIDs are the database platforms that are SUPER-CHIP on the HP48,
+// which scroll and count collisions in ways the database has no quirks for.
+var superchipIDs = map[string]bool{"superchip1": true, "superchip": true}
+
+// platformIDs are the database platforms this emulator can run.
+var platformIDs = map[string]cpu.Platform{
+  "originalChip8": cpu.PlatformCHIP8,
+  "hybridVIP":     cpu.PlatformCHIP8,
+  "modernChip8":   cpu.PlatformCHIP8,
+  "chip8x":        cpu.PlatformCHIP8,
+  "chip48":        cpu.PlatformSCHIP,
+  "superchip1":    cpu.PlatformSCHIP,
+  "superchip":     cpu.PlatformSCHIP,
+  "xochip":        cpu.PlatformXOCHIP,
+}
+
+// Match is a ROM found in the database and the settings to run it with.
+type Match struct {
+  Program *Program
+  ROM     *ROM
+  // PlatformID is the database platform chosen, the first in the ROM's
+  // list that can be emulated.
+  PlatformID string
+  Platform   cpu.Platform
+  Quirks     cpu.Quirks
+  // Tickrate is instructions per frame, 0 if neither the ROM nor its
+  // platform says.
+  Tickrate int
+  Keys     map[string]uint8
+  // Palette is nil if the ROM has no colors.
+  Palette color.Palette
+}
+
+// Hash is the key of rom in the database.
+func Hash(rom []byte) string {
+  sum := sha1.Sum(rom)
+  return hex.EncodeToString(sum[:])
+}
+
+// Lookup identifies rom. ok is false for a ROM the database doesn't have,
+// or that only runs on platforms that can't be emulated.
+func (db *Database) Lookup(rom []byte) (*Match, bool) {
+  hash := Hash(rom)
+  j, ok := db.hashes[hash]
+  if !ok {
+    return nil, false
+  }
+  p := &db.programs[j]
+  r, ok := p.ROMs[hash]
+  if !ok {
+    return nil, false
+  }
+  for _, id := range r.Platforms {
+    platform, ok := platformIDs[id]
+    if !ok {
+      continue
+    }
+    quirks := Quirks{}
+    for k, v := range db.platforms[id].Quirks {
+      quirks[k] = v
+    }
+    for k, v := range r.QuirkyPlatforms[id] {
+      quirks[k] = v
+    }
+    cq := quirks.CPU()
+    if superchipIDs[id] {
+      cq.HalfScroll, cq.CountCollisions = true, true
+    }
+    m := &Match{
+      Program:    p,
+      ROM:        &r,
+      PlatformID: id,
+      Platform:   platform,
+      Quirks:     cq,
+      Tickrate:   r.Tickrate,
+      Keys:       r.Keys,
+    }
+    if m.Tickrate == 0 {
+      m.Tickrate = db.platforms[id].DefaultTickrate
+    }
+    if r.Colors != nil {
+      m.Palette = r.Colors.Palette()
+    }
+    return m, true
+  }
+  return nil, false
+}
+
+// CPU translates the quirks.
+func (q Quirks) CPU() cpu.Quirks {
+  leave, byX := q["memoryLeaveIUnchanged"], q["memoryIncrementByX"]
+  return cpu.Quirks{
+    ShiftVX:       q["shift"],
+    IncrementI:    !leave && !byX,
+    IncrementIByX: !leave && byX,
+    JumpVX:        q["jump"],
+    ResetVF:       q["logic"],
+    ClipSprites:   !q["wrap"],
+    DisplayWait:   q["vblank"],
+  }
+}
+
+// Palette returns the pixel colors, repeating the last one given to make
+// four. It is nil if there are none or one doesn't parse.
+func (c *Colors) Palette() color.Palette {
+  if len(c.Pixels) == 0 {
+    return nil
+  }
+  p := make(color.Palette, 4)
+  for j := range p {
+    s := strings.TrimPrefix(c.Pixels[min(j, len(c.Pixels) - 1)], "#")
+    v, err := strconv.ParseUint(s, 16, 32)
+    if err != nil || len(s) != 6 {
+      return nil
+    }
+    p[j] = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+  }
+  return p
+}
@@ -0,0 +1,130 @@
+package romdb
+
+import (
+  "image/color"
+  "os"
+  "testing"
+  "testing/fstest"
+
+  "cryp-8/cpu"
+)
+
+func TestEmbedded(t *testing.T) {
+  db, err := loadEmbedded()
+  if err != nil {
+    t.Fatalf("Built-in database does not load: %v", err)
+  }
+  if len(db.programs) == 0 || len(db.platforms) == 0 {
+    t.Errorf("Built-in database is empty")
+  }
+  if Default() != Default() {
+    t.Errorf("Default loaded the database twice")
+  }
+}
+
+func TestDefault(t *testing.T) {
+  rom, err := os.ReadFile("../bowling.ch8")
+  if err != nil {
+    t.Fatal(err)
+  }
+  m, ok := Default().Lookup(rom)
+  if !ok {
+    t.Fatal("bowling.ch8 was not identified")
+  }
+  if m.Program.Title != "Bowling" || m.Platform != cpu.PlatformCHIP8 || m.Tickrate != 15 {
+    t.Errorf("Incorrect match %+v", m)
+  }
+  if m.Quirks != cpu.QuirksVIP {
+    t.Errorf("Incorrect quirks. Got %+v, wanted %+v", m.Quirks, cpu.QuirksVIP)
+  }
+  if _, ok := Default().Lookup([]byte{0x12, 0x00}); ok {
+    t.Errorf("Unknown ROM was identified")
+  }
+}
+
+var testDB = fstest.MapFS{
+  "sha1-hashes.json": {Data: []byte(`{"` + Hash([]byte{0x00, 0xE0}) + `": 0}`)},
+  "programs.json": {Data: []byte(`[{
+    "title": "Clear",
+    "roms": {"` + Hash([]byte{0x00, 0xE0}) + `": {
+      "platforms": ["megachip8", "xochip"],
+      "quirkyPlatforms": {"xochip": {"wrap": false, "logic": true}},
+      "keys": {"up": 5, "a": 6},
+      "colors": {"pixels": ["#000000", "#ff8000"]}
+    }}
+  }]`)},
+  "platforms.json": {Data: []byte(`[
+    {"id": "xochip", "defaultTickrate": 100, "quirks": {"wrap": true, "shift": false}}
+  ]`)},
+}
+
+func TestLookup(t *testing.T) {
+  db, err := Load(testDB)
+  if err != nil {
+    t.Fatal(err)
+  }
+  m, ok := db.Lookup([]byte{0x00, 0xE0})
+  if !ok {
+    t.Fatal("ROM was not identified")
+  }
+  // MEGA-CHIP can't be emulated, so the next platform is used
+  if m.PlatformID != "xochip" || m.Platform != cpu.PlatformXOCHIP || m.Tickrate != 100 {
+    t.Errorf("Incorrect platform %v %v %v", m.PlatformID, m.Platform, m.Tickrate)
+  }
+  if want := (cpu.Quirks{IncrementI: true, ResetVF: true, ClipSprites: true}); m.Quirks != want {
+    t.Errorf("Incorrect quirks. Got %+v, wanted %+v", m.Quirks, want)
+  }
+  if m.Keys["up"] != 5 || m.Keys["a"] != 6 {
+    t.Errorf("Incorrect keys %v", m.Keys)
+  }
+  orange := color.RGBA{0xff, 0x80, 0x00, 0xff}
+  if len(m.Palette) != 4 || m.Palette[0] != (color.RGBA{0, 0, 0, 0xff}) || m.Palette[1] != orange || m.Palette[3] != orange {
+    t.Errorf("Incorrect palette %v", m.Palette)
+  }
+}
+
+func TestQuirks(t *testing.T) {
+  for _, tt := range []struct {
+    q    Quirks
+    want cpu.Quirks
+  }{
+    {Quirks{"wrap": true}, cpu.Quirks{IncrementI: true}},
+    {Quirks{"wrap": true, "memoryIncrementByX": true}, cpu.Quirks{IncrementIByX: true}},
+    {Quirks{"wrap": true, "memoryIncrementByX": true, "memoryLeaveIUnchanged": true}, cpu.Quirks{}},
+  } {
+    if got := tt.q.CPU(); got != tt.want {
+      t.Errorf("Incorrect quirks for %v. Got %+v, wanted %+v", tt.q, got, tt.want)
+    }
+  }
+
+  db, err := Load(fstest.MapFS{
+    "sha1-hashes.json": {Data: []byte(`{"` + Hash([]byte{0x00, 0xFF}) + `": 0}`)},
+    "programs.json": {Data: []byte(`[{"title": "Hires", "roms": {"` + Hash([]byte{0x00, 0xFF}) + `": {"platforms": ["superchip"]}}}]`)},
+    "platforms.json": {Data: []byte(`[{"id": "superchip", "quirks": {"memoryLeaveIUnchanged": true}}]`)},
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  m, ok := db.Lookup([]byte{0x00, 0xFF})
+  if !ok {
+    t.Fatal("ROM was not identified")
+  }
+  if !m.Quirks.HalfScroll || !m.Quirks.CountCollisions {
+    t.Errorf("Expected the HP48 SUPER-CHIP quirks, got %+v", m.Quirks)
+  }
+}
+
+func TestLoadErrors(t *testing.T) {
+  bad := fstest.MapFS{}
+  for name, f := range testDB {
+    bad[name] = f
+  }
+  bad["sha1-hashes.json"] = &fstest.MapFile{Data: []byte(`{"abc": 3}`)}
+  if _, err := Load(bad); err == nil {
+    t.Errorf("Expected an error for a hash of a missing program")
+  }
+  delete(bad, "platforms.json")
+  if _, err := Load(bad); err == nil {
+    t.Errorf("Expected an error for a missing file")
+  }
+}
@@ -0,0 +1,94 @@
+// Package symbols relates ROM addresses to the assembler source they came
+// from, so debuggers can show source lines and set breakpoints on them.
+package symbols
+
+import (
+  "encoding/json"
+  "io"
+  "path/filepath"
+  "sort"
+)
+
+// Line says that the code at Addr was assembled from a line of File.
+// Lines are 1-based.
+type Line struct {
+  File string `json:"file"`
+  Line int    `json:"line"`
+  Addr uint16 `json:"addr"`
+}
+
+// Map is a symbol map as written next to an assembled ROM.
+type Map struct {
+  Lines  []Line            `json:"lines"`
+  Labels map[string]uint16 `json:"labels"`
+}
+
+// Read decodes a JSON symbol map.
+func Read(r io.Reader) (*Map, error) {
+  var m Map
+  if err := json.NewDecoder(r).Decode(&m); err != nil {
+    return nil, err
+  }
+  sort.SliceStable(m.Lines, func(a, b int) bool {
+    return m.Lines[a].Addr < m.Lines[b].Addr
+  })
+  return &m, nil
+}
+
+// Write encodes the map as JSON.
+func (m *Map) Write(w io.Writer) error {
+  e := json.NewEncoder(w)
+  e.SetIndent("", "  ")
+  return e.Encode(m)
+}
+
+// Source finds the line the code at addr came from.
+func (m *Map) Source(addr uint16) (Line, bool) {
+  for _, l := range m.Lines {
+    if l.Addr == addr {
+      return l, true
+    }
+  }
+  return Line{}, false
+}
+
+// Address finds the code for a source line. Files match if they are the
+// same path or, failing that, have the same base name. A line without
+// code resolves to the next line in the file that has some; the returned
+// Line says which.
+func (m *Map) Address(file string, line int) (Line, bool) {
+  var best Line
+  found := false
+  for _, exact := range []bool{true, false} {
+    for _, l := range m.Lines {
+      if !sameFile(l.File, file, exact) || l.Line < line {
+        continue
+      }
+      if !found || l.Line < best.Line || (l.Line == best.Line && l.Addr < best.Addr) {
+        best, found = l, true
+      }
+    }
+    if found {
+      break
+    }
+  }
+  return best, found
+}
+
+// Label finds the closest label at or before addr.
+func (m *Map) Label(addr uint16) (string, uint16, bool) {
+  name, at, found := "", uint16(0), false
+  for n, a := range m.Labels {
+    if a <= addr && (!found || a > at || (a == at && n < name)) {
+      name, at, found = n, a, true
+    }
+  }
+  return name, at, found
+}
+
+func sameFile(a, b string, exact bool) bool {
+  if exact {
+    return filepath.Clean(a) == filepath.Clean(b)
+  }
+  return filepath.Base(a) == filepath.Base(b)
+}
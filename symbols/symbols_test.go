@@ -0,0 +1,48 @@
+package symbols
+
+import (
+  "bytes"
+  "testing"
+)
+
+var example = &Map{
+  Lines: []Line{
+    {"game.8o", 3, 0x200},
+    {"game.8o", 4, 0x202},
+    {"game.8o", 7, 0x204},
+    {"lib/draw.8o", 2, 0x206},
+  },
+  Labels: map[string]uint16{"main": 0x200, "loop": 0x204, "draw": 0x206},
+}
+
+func TestRoundTrip(t *testing.T) {
+  var buf bytes.Buffer
+  if err := example.Write(&buf); err != nil {
+    t.Fatal(err)
+  }
+  m, err := Read(&buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(m.Lines) != 4 || m.Labels["draw"] != 0x206 {
+    t.Errorf("Incorrect map %+v", m)
+  }
+}
+
+func TestLookup(t *testing.T) {
+  if l, ok := example.Source(0x202); !ok || l.Line != 4 {
+    t.Errorf("Incorrect source %v", l)
+  }
+  if l, ok := example.Address("game.8o", 5); !ok || l.Addr != 0x204 || l.Line != 7 {
+    t.Errorf("Incorrect address for line 5: %v", l)
+  }
+  if l, ok := example.Address("/home/me/lib/draw.8o", 1); !ok || l.Addr != 0x206 {
+    t.Errorf("Incorrect address by base name: %v", l)
+  }
+  if _, ok := example.Address("game.8o", 8); ok {
+    t.Errorf("Expected no code after the last line")
+  }
+  if name, at, ok := example.Label(0x205); !ok || name != "loop" || at != 0x204 {
+    t.Errorf("Incorrect label %v %#x", name, at)
+  }
+}
@@ -0,0 +1,613 @@
+//go:build !nogl
+
+package main
+
+import (
+  "cryp-8/cpu"
+  "cryp-8/movie"
+  "cryp-8/rewind"
+  "flag"
+  "fmt"
+  "image/color"
+  "time"
+  "log"
+  "strings"
+
+  "math/rand"
+  "os"
+  "runtime"
+  "github.com/go-gl/gl/v2.1/gl"
+  "github.com/go-gl/glfw/v3.2/glfw"
+)
+
+type app struct {
+  cpu *cpu.CPU
+  romPath string
+  rom []byte
+  // opts and machine.cycles configure the CPU the ROM is started on
+  opts []cpu.Option
+  machine machineFlags
+  cycles int
+  // keymap is keymap plus the bindings the ROM database gives the game
+  keymap map[glfw.Key]uint8
+  slot int
+  // rewind is nil when rewinding is turned off
+  rewind *rewind.Buffer
+  rewinding bool
+  recorder *movie.Recorder
+  player *movie.Player
+}
+
+const (
+  width  = 512
+  height = 256
+
+  vertexShaderSource = `
+    #version 410
+    in vec3 vp;
+    void main() {
+      gl_Position = vec4(vp, 1.0);
+    }
+  ` + "\x00"
+
+  fragmentShaderSource = `
+    #version 410
+    uniform vec4 colour;
+    out vec4 frag_colour;
+    void main() {
+      frag_colour = colour;
+    }
+  ` + "\x00"
+
+  threshold = 0.15
+  fps = 60
+  // a snapshot is taken every rewindInterval frames
+  rewindInterval = 1
+)
+
+var (
+  square = []float32{
+    -0.5, 0.5, 0,
+    -0.5, -0.5, 0,
+    0.5, -0.5, 0,
+
+    -0.5, 0.5, 0,
+    0.5, 0.5, 0,
+    0.5, -0.5, 0,
+  }
+  shouldRun = true
+
+)
+
+type cell struct {
+    drawable uint32
+
+
+    alive     bool
+    aliveNext bool
+    // color is the value cpu.Colors gave the pixel
+    color     uint8
+
+    x int
+    y int
+}
+
+
+var keymap = map[glfw.Key]uint8{
+  glfw.Key0: 0x0,
+  glfw.Key1: 0x1,
+  glfw.Key2: 0x2,
+  glfw.Key3: 0x3,
+  glfw.Key4: 0x4,
+  glfw.Key5: 0x5,
+  glfw.Key6: 0x6,
+  glfw.Key7: 0x7,
+  glfw.Key8: 0x8,
+  glfw.Key9: 0x9,
+  glfw.KeyA: 0xa,
+  glfw.KeyB: 0xb,
+  glfw.KeyC: 0xc,
+  glfw.KeyD: 0xd,
+  glfw.KeyE: 0xe,
+  glfw.KeyF: 0xf,
+}
+
+// gameKeys are the keyboard keys for the game inputs the ROM database
+// names: the arrows, Z and X for player one and IJKL, N and M for player
+// two.
+var gameKeys = map[string]glfw.Key{
+  "up":           glfw.KeyUp,
+  "down":         glfw.KeyDown,
+  "left":         glfw.KeyLeft,
+  "right":        glfw.KeyRight,
+  "a":            glfw.KeyZ,
+  "b":            glfw.KeyX,
+  "player2Up":    glfw.KeyI,
+  "player2Down":  glfw.KeyK,
+  "player2Left":  glfw.KeyJ,
+  "player2Right": glfw.KeyL,
+  "player2A":     glfw.KeyN,
+  "player2B":     glfw.KeyM,
+}
+
+// bindKeys adds the game's inputs to the hex keys of keymap.
+func bindKeys(keys map[string]uint8) map[glfw.Key]uint8 {
+  m := map[glfw.Key]uint8{}
+  for key, k := range keymap {
+    m[key] = k
+  }
+  for input, k := range keys {
+    if key, ok := gameKeys[input]; ok && k <= 0xf {
+      m[key] = k
+    }
+  }
+  return m
+}
+
+func (h *app) onKey(w *glfw.Window, key glfw.Key, scancode int,
+  action glfw.Action, mods glfw.ModifierKey) {
+  k, isPad := h.keymap[key]
+  switch action {
+    case glfw.Press:
+      if isPad {
+        h.keyDown(k)
+      }
+    case glfw.Release:
+      if isPad {
+        h.keyUp(k)
+      }
+      if key == glfw.KeyBackspace {
+        h.rewinding = false
+      }
+      return
+    default:
+      return
+  }
+  if key == glfw.KeyEscape {
+    w.SetShouldClose(true)
+  }
+  if key == glfw.KeySpace {
+    shouldRun = true
+  }
+  switch key {
+    case glfw.KeyF1, glfw.KeyF2, glfw.KeyF3, glfw.KeyF4:
+      h.slot = int(key - glfw.KeyF1) + 1
+      log.Println("quick save slot", h.slot)
+    case glfw.KeyF5:
+      h.quickSave()
+    case glfw.KeyF9:
+      h.quickLoad()
+    case glfw.KeyF6:
+      h.toggleRecording()
+    case glfw.KeyF7:
+      h.playMovie()
+    case glfw.KeyBackspace:
+      // rewinding would desync a movie
+      h.rewinding = h.rewind != nil && h.recorder == nil && h.player == nil
+  }
+}
+
+// keyDown and keyUp route keypad input through the movie recorder when
+// one is running. Live input is ignored while a movie plays.
+func (h *app) keyDown(k uint8) {
+  switch {
+    case h.player != nil:
+    case h.recorder != nil:
+      h.recorder.KeyDown(k)
+    default:
+      h.cpu.KeyDown(k)
+  }
+}
+
+func (h *app) keyUp(k uint8) {
+  switch {
+    case h.player != nil:
+    case h.recorder != nil:
+      h.recorder.KeyUp(k)
+    default:
+      h.cpu.KeyUp(k)
+  }
+}
+
+// beginFrame hands the movie's input for this frame to the CPU.
+func (h *app) beginFrame() {
+  if h.recorder != nil {
+    h.recorder.BeginFrame()
+  }
+  if h.player != nil && !h.player.BeginFrame() {
+    log.Println("movie finished")
+    h.player = nil
+  }
+}
+
+// restart recreates the CPU with opts and reloads the ROM.
+func (h *app) restart(opts ...cpu.Option) {
+  *h.cpu = cpu.NewCPU(opts...)
+  h.cpu.LoadRom(h.rom)
+  if h.rewind != nil {
+    h.rewind.Reset()
+  }
+}
+
+// moviePath is where the movie for the current ROM is recorded.
+func (h *app) moviePath() string {
+  return h.romPath + ".movie"
+}
+
+// toggleRecording restarts the ROM and records a movie, or stops the
+// recording and saves it.
+func (h *app) toggleRecording() {
+  if h.recorder == nil {
+    h.player = nil
+    h.restart(h.opts...)
+    h.cycles = h.machine.cycles
+    r, err := movie.NewRecorder(h.cpu, h.cycles)
+    if err != nil {
+      log.Println(err)
+      return
+    }
+    h.recorder = r
+    log.Println("recording movie")
+    return
+  }
+  m := h.recorder.Movie()
+  h.recorder = nil
+  f, err := os.Create(h.moviePath())
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  defer f.Close()
+  if err := m.Write(f); err != nil {
+    log.Println(err)
+    return
+  }
+  log.Println("saved", h.moviePath())
+}
+
+// playMovie restarts the ROM as the movie was recorded and plays it back.
+func (h *app) playMovie() {
+  f, err := os.Open(h.moviePath())
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  defer f.Close()
+  m, err := movie.Read(f)
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  h.recorder = nil
+  h.restart(append(m.Options(), h.machine.engine()...)...)
+  h.player, err = movie.NewPlayer(h.cpu, m)
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  h.cycles = m.Cycles
+  log.Println("playing", h.moviePath())
+}
+
+// statePath is where the current quick save slot is stored.
+func (h *app) statePath() string {
+  return fmt.Sprintf("%s.state%d", h.romPath, h.slot)
+}
+
+func (h *app) quickSave() {
+  f, err := os.Create(h.statePath())
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  defer f.Close()
+  if err := h.cpu.SaveState(f); err != nil {
+    log.Println(err)
+    return
+  }
+  log.Println("saved", h.statePath())
+}
+
+// quickLoad restores the current slot. It is refused while a movie records
+// or plays, since the movie couldn't replay the jump.
+func (h *app) quickLoad() {
+  if h.recorder != nil || h.player != nil {
+    log.Println("stop the movie before loading a state")
+    return
+  }
+  f, err := os.Open(h.statePath())
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  defer f.Close()
+  if err := h.cpu.LoadState(f); err != nil {
+    log.Println(err)
+    return
+  }
+  log.Println("loaded", h.statePath())
+}
+
+// runWindow is the run command: it plays a ROM in a GLFW window.
+func runWindow(args []string) error {
+  fs := flag.NewFlagSet("run", flag.ExitOnError)
+  var machine machineFlags
+  machine.register(fs)
+  rewindSeconds := fs.Float64("rewind", 10, "seconds of play Backspace can rewind, 0 to turn rewind off")
+  fs.Parse(args)
+  if fs.NArg() != 1 || *rewindSeconds < 0 {
+    return errUsage
+  }
+  romPath := fs.Arg(0)
+  p, err := machine.load(fs, romPath)
+  if err != nil {
+    return err
+  }
+
+  runtime.LockOSThread()
+
+  window := initGlfw()
+  defer glfw.Terminate()
+  program := initOpenGL()
+  if p.title != "" {
+    window.SetTitle("cryp-8 - " + p.title)
+  }
+
+  fmt.Println("Welcome to cryp-8, the only chip-8 emulator in existence.")
+  cpu := cpu.NewCPU(p.opts...)
+  h := app{cpu: &cpu, romPath: romPath, rom: p.rom, opts: p.opts, machine: machine,
+    cycles: p.cycles, keymap: bindKeys(p.keys), slot: 1}
+  if depth := int(*rewindSeconds*fps/rewindInterval); depth > 0 {
+    h.rewind = rewind.New(depth, rewindInterval)
+  }
+  window.SetKeyCallback(h.onKey)
+  cpu.LoadRom(p.rom)
+  var iteration_times [100]float64
+  cells := makeCells(cpu.Width(), cpu.Height())
+  var colors []uint8
+
+  for i := 0; !window.ShouldClose(); i %= 100 {
+    t := time.Now()
+    if h.rewinding {
+      // holding backspace plays the game backwards one snapshot a frame
+      if _, err := h.rewind.Rewind(&cpu); err != nil {
+        log.Println(err)
+      }
+    } else if shouldRun {
+      h.beginFrame()
+      if err := cpu.RunFrame(h.cycles); err != nil {
+        log.Println(err)
+        shouldRun = false
+      }
+      if h.rewind != nil {
+        if err := h.rewind.Capture(&cpu); err != nil {
+          log.Println(err)
+        }
+      }
+      // shouldRun = false
+    }
+    glfw.PollEvents()
+
+    if cpu.RefreshScreen {        
+      w, h := cpu.Width(), cpu.Height()
+      if len(cells) != w || len(cells[0]) != h {
+        freeCells(cells)
+        cells = makeCells(w, h)
+      }
+      colors = cpu.Colors(colors[:0])
+      for x := range cells {
+        for y, c := range cells[x] {
+          c.color = colors[w*(h - 1 - y) + (x)]
+          c.alive = c.color != 0
+        }
+      }
+      draw(cells, window, program, p.palette)
+      cpu.RefreshScreen = false
+    }
+
+    if i == 99 {
+      // fmt.Printf("cycles per second: %v\n", calcCPS(iteration_times[:]))
+    }
+    time.Sleep(time.Second/time.Duration(fps) - time.Since(t))
+    iteration_times[i] = time.Since(t).Seconds()
+    i++
+  }
+  return nil
+}
+
+
+func calcCPS(x []float64) float64 {
+  var total float64 = 0
+  for _, value:= range x {
+    total += value
+  }
+  return float64(len(x))/total
+}
+
+// initGlfw initializes glfw and returns a Window to use.
+func initGlfw() *glfw.Window {
+  if err := glfw.Init(); err != nil {
+    panic(err)
+  }
+  glfw.WindowHint(glfw.Resizable, glfw.False)
+  glfw.WindowHint(glfw.ContextVersionMajor, 4)
+  glfw.WindowHint(glfw.ContextVersionMinor, 1)
+  glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+  glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+
+  window, err := glfw.CreateWindow(width, height, "cryp-8", nil, nil)
+  if err != nil {
+    panic(err)
+  }
+  window.MakeContextCurrent()
+
+  return window
+}
+
+// initOpenGL initializes OpenGL and returns an intiialized program.
+func initOpenGL() uint32 {
+  if err := gl.Init(); err != nil {
+    panic(err)
+  }
+  version := gl.GoStr(gl.GetString(gl.VERSION))
+  log.Println("OpenGL version", version)
+
+  vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+  if err != nil {
+    panic(err)
+  }
+
+  fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+  if err != nil {
+    panic(err)
+  }
+
+  prog := gl.CreateProgram()
+  gl.AttachShader(prog, vertexShader)
+  gl.AttachShader(prog, fragmentShader)
+  gl.LinkProgram(prog)
+  return prog
+}
+
+// makeVao initializes and returns a vertex array from the points provided.
+func makeVao(points []float32) uint32 {
+  var vbo uint32
+  gl.GenBuffers(1, &vbo)
+  gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+  gl.BufferData(gl.ARRAY_BUFFER, 4*len(points), gl.Ptr(points), gl.STATIC_DRAW)
+
+  var vao uint32
+  gl.GenVertexArrays(1, &vao)
+  gl.BindVertexArray(vao)
+  gl.EnableVertexAttribArray(0)
+  gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+  gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 0, nil)
+
+  return vao
+}
+
+func compileShader(source string, shaderType uint32) (uint32, error) {
+  shader := gl.CreateShader(shaderType)
+
+  csources, free := gl.Strs(source)
+  gl.ShaderSource(shader, 1, csources, nil)
+  free()
+  gl.CompileShader(shader)
+
+  var status int32
+  gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+  if status == gl.FALSE {
+    var logLength int32
+    gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+
+    log := strings.Repeat("\x00", int(logLength+1))
+    gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+
+    return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+  }
+
+  return shader, nil
+}
+
+// draw clears to the background color of palette and draws the cells of
+// each other color in turn.
+func draw(cells [][]*cell, window *glfw.Window, program uint32, palette color.Palette) {
+  r, g, b, _ := glColor(palette[0])
+  gl.ClearColor(r, g, b, 1)
+  gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+  gl.UseProgram(program)
+
+  uniform := gl.GetUniformLocation(program, gl.Str("colour\x00"))
+  for v := 1; v < len(palette); v++ {
+    r, g, b, a := glColor(palette[v])
+    gl.Uniform4f(uniform, r, g, b, a)
+    for x := range cells {
+      for _, c := range cells[x] {
+        if int(c.color) == v {
+          c.draw()
+        }
+      }
+    }
+  }
+
+  glfw.PollEvents()
+  window.SwapBuffers()
+}
+
+
+// glColor converts c to OpenGL's 0 to 1 components.
+func glColor(c color.Color) (float32, float32, float32, float32) {
+  r, g, b, a := c.RGBA()
+  return float32(r)/0xffff, float32(g)/0xffff, float32(b)/0xffff, float32(a)/0xffff
+}
+
+func makeCells(rows, columns int) [][]*cell {
+    rand.Seed(time.Now().UnixNano())
+
+    cells := make([][]*cell, rows)
+    for x := 0; x < rows; x++ {
+        for y := 0; y < columns; y++ {
+            c := newCell(x, y, rows, columns)
+            
+            c.alive = true
+            c.aliveNext = c.alive
+            
+            cells[x] = append(cells[x], c)
+        }
+    }
+
+  return cells
+}
+
+// freeCells releases the vertex arrays of cells made by makeCells.
+func freeCells(cells [][]*cell) {
+  for x := range cells {
+    for _, c := range cells[x] {
+      gl.DeleteVertexArrays(1, &c.drawable)
+    }
+  }
+}
+
+func newCell(x, y, rows, columns int) *cell {
+  // fmt.Println(x,y)
+  points := make([]float32, len(square), len(square))
+  copy(points, square)
+
+  for i := 0; i < len(points); i++ {
+    var position float32
+    var size float32
+    switch i % 3 {
+    case 0:
+      size = 1.0 / float32(rows)
+      position = float32(x) * size
+      // fmt.Println("x=",position)
+    case 1:
+      size = 1.0 / float32(columns)
+      position = float32(y) * size
+      // fmt.Println("y=",position)
+    default:
+      continue
+    }
+
+    if points[i] < 0 {
+      points[i] = (position * 2) - 1
+    } else {
+      points[i] = ((position + size) * 2) - 1
+    }
+  }
+
+  return &cell{
+    drawable: makeVao(points),
+
+    x: x,
+    y: y,
+  }
+}
+func (c *cell) draw() {
+    if !c.alive {
+            return
+    }
+
+    gl.BindVertexArray(c.drawable)
+    gl.DrawArrays(gl.TRIANGLES, 0, int32(len(square)/3))
+}
\ No newline at end of file
@@ -0,0 +1,12 @@
+//go:build nogl
+
+package main
+
+import (
+  "errors"
+)
+
+// runWindow is unavailable in builds without OpenGL; use headless instead.
+func runWindow(args []string) error {
+  return errors.New("run: built without OpenGL (nogl), use headless")
+}